@@ -0,0 +1,181 @@
+package collections
+
+import (
+	"context"
+	"github.com/go-errors/errors"
+	"time"
+)
+
+/*
+ __    _           ___
+|  |  |_|_____ ___|_  |
+|  |__| |     | .'|  _|
+|_____|_|_|_|_|__,|___|
+zed (14.03.2024)
+*/
+
+// Correlator matches a result with the callers waiting for it under the same key.
+// HolderMap, WaitMap, ResponseMap and RequestMap implement it through their Correlator methods,
+// the differences between them are described by CorrelationPolicy
+type Correlator[K comparable, V any] interface {
+	// Wait blocks until the result of key is resolved, the wait timeout is exceeded or ctx is done
+	Wait(ctx context.Context, key K) (V, error)
+	// Resolve passes the result of key to the waiters
+	Resolve(key K, value V) error
+	Policy() CorrelationPolicy
+}
+
+type CorrelationCreator int
+
+const (
+	// CreatorWaiter - the entry is created by the first Wait, Resolve without waiters fails
+	CreatorWaiter CorrelationCreator = iota
+	// CreatorAny - the entry is created by the first Wait or Resolve, a result resolved before Wait is kept for ResultTTL
+	CreatorAny
+	// CreatorProducer - the first Wait calls the producer func, its result is shared by all waiters
+	CreatorProducer
+)
+
+type CorrelationWriteMode int
+
+const (
+	// FirstWriteWins - later Resolve calls for the same key are ignored
+	FirstWriteWins CorrelationWriteMode = iota
+	// LastWriteWins - later Resolve calls replace the result returned to the next waiters
+	LastWriteWins
+)
+
+type CorrelationPolicy struct {
+	Creator   CorrelationCreator
+	WriteMode CorrelationWriteMode
+	// ResultTTL is how long a resolved result is kept for the next waiters
+	ResultTTL time.Duration
+	// WaitTimeout is the timeout of a wait, applied to the entry and not to a single Wait call. 0 means no timeout
+	WaitTimeout time.Duration
+	// Cancellable reports if Wait returns when ctx is done
+	Cancellable bool
+}
+
+type holderCorrelator[K comparable, V any] struct {
+	m *HolderMap[K, V]
+}
+
+// Correlator returns HolderMap as Correlator. Resolve updates the held object and releases the waiters
+func (c *HolderMap[K, V]) Correlator() Correlator[K, V] {
+	return &holderCorrelator[K, V]{m: c}
+}
+
+// Wait returns ErrTimeout and ErrCancelled instead of the HolderMap errors, like the other correlators
+func (c *holderCorrelator[K, V]) Wait(ctx context.Context, key K) (V, error) {
+	var target V
+	v, err := c.m.WaitCtx(ctx, key, target)
+	switch {
+	case errors.Is(err, ErrHolderTimeout):
+		err = ErrTimeout
+	case errors.Is(err, ErrHolderCancelled):
+		err = ErrCancelled
+	}
+	return v, err
+}
+
+func (c *holderCorrelator[K, V]) Resolve(key K, value V) error {
+	if err := c.m.Update(key, value); err != nil {
+		return err
+	}
+	return c.m.Release(key)
+}
+
+func (c *holderCorrelator[K, V]) Policy() CorrelationPolicy {
+	return CorrelationPolicy{
+		Creator:     CreatorWaiter,
+		WriteMode:   LastWriteWins,
+		ResultTTL:   c.m.destroyElementDuration,
+		WaitTimeout: c.m.timeoutDuration,
+		Cancellable: true,
+	}
+}
+
+type waitCorrelator[K comparable, V any] struct {
+	m *WaitMap[K, V]
+}
+
+func (wm *WaitMap[K, V]) Correlator() Correlator[K, V] {
+	return &waitCorrelator[K, V]{m: wm}
+}
+
+func (c *waitCorrelator[K, V]) Wait(ctx context.Context, key K) (V, error) {
+	return c.m.WaitCtx(ctx, key)
+}
+
+func (c *waitCorrelator[K, V]) Resolve(key K, value V) error {
+	c.m.Set(key, value)
+	return nil
+}
+
+func (c *waitCorrelator[K, V]) Policy() CorrelationPolicy {
+	return CorrelationPolicy{
+		Creator:     CreatorAny,
+		WriteMode:   FirstWriteWins,
+		ResultTTL:   c.m.responseTtl,
+		WaitTimeout: c.m.requestTimeout,
+		Cancellable: true,
+	}
+}
+
+type responseCorrelator[K comparable, V any] struct {
+	m *ResponseMap[K, V]
+}
+
+func (r *ResponseMap[K, V]) Correlator() Correlator[K, V] {
+	return &responseCorrelator[K, V]{m: r}
+}
+
+func (c *responseCorrelator[K, V]) Wait(ctx context.Context, key K) (V, error) {
+	return c.m.WaitCtx(ctx, key)
+}
+
+func (c *responseCorrelator[K, V]) Resolve(key K, value V) error {
+	c.m.Set(key, value)
+	return nil
+}
+
+func (c *responseCorrelator[K, V]) Policy() CorrelationPolicy {
+	return CorrelationPolicy{
+		Creator:     CreatorAny,
+		WriteMode:   FirstWriteWins,
+		ResultTTL:   c.m.clearTimeout,
+		WaitTimeout: c.m.responseTimeout,
+		Cancellable: true,
+	}
+}
+
+type requestCorrelator[K comparable, V any] struct {
+	m *RequestMap[K, V]
+	f func(k K) (V, error)
+}
+
+// Correlator returns RequestMap as Correlator, the first Wait of a key calls f.
+// Resolve completes the key without calling f, the result of f is ignored if the key is already resolved
+func (rm *RequestMap[K, V]) Correlator(f func(k K) (V, error)) Correlator[K, V] {
+	return &requestCorrelator[K, V]{m: rm, f: f}
+}
+
+func (c *requestCorrelator[K, V]) Wait(ctx context.Context, key K) (V, error) {
+	return c.m.GetOrCreateCtx(ctx, key, func(_ context.Context, k K) (V, error) {
+		return c.f(k)
+	})
+}
+
+func (c *requestCorrelator[K, V]) Resolve(key K, value V) error {
+	c.m.resolve(key, value)
+	return nil
+}
+
+func (c *requestCorrelator[K, V]) Policy() CorrelationPolicy {
+	return CorrelationPolicy{
+		Creator:     CreatorProducer,
+		WriteMode:   FirstWriteWins,
+		ResultTTL:   c.m.deleteAfter,
+		Cancellable: true,
+	}
+}
@@ -0,0 +1,106 @@
+package collections
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"runtime"
+	"testing"
+	"time"
+)
+
+/*
+ __    _           ___
+|  |  |_|_____ ___|_  |
+|  |__| |     | .'|  _|
+|_____|_|_|_|_|__,|___|
+zed (14.03.2024)
+*/
+
+func TestCorrelator(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	correlators := map[string]Correlator[int, string]{
+		"holder":   NewHolderMap[int, string]().WithContext(ctx).WithTimeout(time.Second).Build().Correlator(),
+		"wait":     NewWaitMap[int, string]().WithContext(ctx).WithRequestTimeout(time.Second).Build().Correlator(),
+		"response": NewResponseMap[int, string](ctx).WithResponseTimeout(time.Second).Build().Correlator(),
+		"request": NewRequestMap[int, string](ctx, time.Second).Correlator(func(k int) (string, error) {
+			time.Sleep(time.Second)
+			return "produced", nil
+		}),
+	}
+	for name, c := range correlators {
+		t.Run(name, func(t *testing.T) {
+			assert.True(t, c.Policy().Cancellable)
+			done := make(chan string, 1)
+			go func() {
+				v, err := c.Wait(context.Background(), 1)
+				assert.Nil(t, err)
+				done <- v
+			}()
+			time.Sleep(time.Millisecond * 10)
+			assert.Nil(t, c.Resolve(1, "resolved"))
+			select {
+			case v := <-done:
+				assert.Equal(t, "resolved", v)
+			case <-time.After(time.Millisecond * 100):
+				t.Fatal("waiter was not resolved")
+			}
+			if c.Policy().WriteMode == FirstWriteWins {
+				assert.Nil(t, c.Resolve(1, "second"))
+				v, err := c.Wait(context.Background(), 1)
+				assert.Nil(t, err)
+				assert.Equal(t, "resolved", v)
+			}
+
+			waitCtx, waitCancel := context.WithTimeout(context.Background(), time.Millisecond*10)
+			defer waitCancel()
+			_, err := c.Wait(waitCtx, 2)
+			assert.ErrorIs(t, err, ErrTimeout)
+
+			cancelCtx, cancelWait := context.WithCancel(context.Background())
+			cancelWait()
+			_, err = c.Wait(cancelCtx, 3)
+			assert.ErrorIs(t, err, ErrCancelled)
+		})
+	}
+}
+
+func TestCorrelator_RequestCancelledWaitLeavesNoGoroutine(t *testing.T) {
+	release := make(chan struct{})
+	c := NewRequestMap[int, string](context.Background(), time.Second).Correlator(func(k int) (string, error) {
+		if k != 0 {
+			<-release
+		}
+		return "produced", nil
+	})
+	defer close(release)
+	_, _ = c.Wait(context.Background(), 0) // let the shared scheduler and cleaner goroutines start
+	before := runtime.NumGoroutine()
+	go func() { _, _ = c.Wait(context.Background(), 1) }()
+	for i := 0; i < 100; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+		_, err := c.Wait(ctx, 1)
+		cancel()
+		assert.ErrorIs(t, err, ErrTimeout)
+	}
+	// only the call of the key and its remaining waiter are left
+	assert.LessOrEqual(t, runtime.NumGoroutine(), before+3)
+}
+
+func TestCorrelator_HolderLastWriteWins(t *testing.T) {
+	c := NewHolderMap[int, string]().WithTimeout(time.Second).Build().Correlator()
+	assert.True(t, errors.Is(c.Resolve(1, "value"), ErrHolderNotFound))
+	go func() {
+		time.Sleep(time.Millisecond * 10)
+		assert.Nil(t, c.Resolve(1, "first"))
+		assert.Nil(t, c.Resolve(1, "second"))
+	}()
+	v, err := c.Wait(context.Background(), 1)
+	assert.Nil(t, err)
+	assert.Contains(t, []string{"first", "second"}, v)
+	time.Sleep(time.Millisecond * 10)
+	v, err = c.Wait(context.Background(), 1)
+	assert.Nil(t, err)
+	assert.Equal(t, "second", v)
+}
@@ -0,0 +1,209 @@
+package collections
+
+import (
+	"iter"
+	"sync"
+	"sync/atomic"
+)
+
+/*
+ __    _           ___
+|  |  |_|_____ ___|_  |
+|  |__| |     | .'|  _|
+|_____|_|_|_|_|__,|___|
+zed (11.04.2024)
+*/
+
+// CopyOnWriteMap is a Map for read-mostly data. Reads load an immutable snapshot without locks,
+// every write copies the whole map under a mutex and swaps the snapshot, so writes are O(n)
+type CopyOnWriteMap[K comparable, V any] struct {
+	snapshot atomic.Pointer[map[K]V]
+	mu       sync.Mutex
+}
+
+func NewCopyOnWriteMap[K comparable, V any]() *CopyOnWriteMap[K, V] {
+	m := &CopyOnWriteMap[K, V]{}
+	empty := make(map[K]V)
+	m.snapshot.Store(&empty)
+	return m
+}
+
+func (m *CopyOnWriteMap[K, V]) load() map[K]V {
+	return *m.snapshot.Load()
+}
+
+// write calls fn with a copy of the map under the write lock and publishes the copy if fn returns true
+func (m *CopyOnWriteMap[K, V]) write(fn func(next map[K]V) bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	cur := m.load()
+	next := make(map[K]V, len(cur)+1)
+	for k, v := range cur {
+		next[k] = v
+	}
+	if fn(next) {
+		m.snapshot.Store(&next)
+	}
+}
+
+func (m *CopyOnWriteMap[K, V]) Get(key K) (V, bool) {
+	v, ok := m.load()[key]
+	return v, ok
+}
+
+func (m *CopyOnWriteMap[K, V]) Set(key K, value V) {
+	m.write(func(next map[K]V) bool {
+		next[key] = value
+		return true
+	})
+}
+
+func (m *CopyOnWriteMap[K, V]) Delete(key K) {
+	if !m.Has(key) {
+		return
+	}
+	m.write(func(next map[K]V) bool {
+		delete(next, key)
+		return true
+	})
+}
+
+func (m *CopyOnWriteMap[K, V]) Has(key K) bool {
+	_, ok := m.load()[key]
+	return ok
+}
+
+func (m *CopyOnWriteMap[K, V]) SetIfNotExists(key K, value V) bool {
+	if m.Has(key) {
+		return false
+	}
+	res := false
+	m.write(func(next map[K]V) bool {
+		if _, ok := next[key]; ok {
+			return false
+		}
+		next[key] = value
+		res = true
+		return true
+	})
+	return res
+}
+
+func (m *CopyOnWriteMap[K, V]) SetIfNotExistsWithFunc(key K, fn func() V) (V, bool) {
+	if v, ok := m.Get(key); ok {
+		return v, false
+	}
+	var res V
+	created := false
+	m.write(func(next map[K]V) bool {
+		if v, ok := next[key]; ok {
+			res = v
+			return false
+		}
+		res = fn()
+		next[key] = res
+		created = true
+		return true
+	})
+	return res, created
+}
+
+func (m *CopyOnWriteMap[K, V]) Size() int {
+	return len(m.load())
+}
+
+// Range calls fn for the current snapshot, fn may call the map
+func (m *CopyOnWriteMap[K, V]) Range(fn func(key K, value V) bool) {
+	for k, v := range m.load() {
+		if !fn(k, v) {
+			return
+		}
+	}
+}
+
+func (m *CopyOnWriteMap[K, V]) Keys() []K {
+	cur := m.load()
+	keys := make([]K, 0, len(cur))
+	for k := range cur {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+func (m *CopyOnWriteMap[K, V]) Values() []V {
+	cur := m.load()
+	values := make([]V, 0, len(cur))
+	for _, v := range cur {
+		values = append(values, v)
+	}
+	return values
+}
+
+func (m *CopyOnWriteMap[K, V]) Clear() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	empty := make(map[K]V)
+	m.snapshot.Store(&empty)
+}
+
+func (m *CopyOnWriteMap[K, V]) Compute(key K, fn func(old V, exists bool) (V, ComputeAction)) (V, bool) {
+	var res V
+	var ok bool
+	m.write(func(next map[K]V) bool {
+		var action ComputeAction
+		old, exists := next[key]
+		res, action = fn(old, exists)
+		switch action {
+		case ComputeSet:
+			next[key] = res
+			ok = true
+			return true
+		case ComputeDelete:
+			delete(next, key)
+			var empty V
+			res = empty
+			return exists
+		default:
+			res, ok = old, exists
+			return false
+		}
+	})
+	return res, ok
+}
+
+func (m *CopyOnWriteMap[K, V]) GetAndDelete(key K) (V, bool) {
+	var res V
+	var ok bool
+	m.write(func(next map[K]V) bool {
+		res, ok = next[key]
+		delete(next, key)
+		return ok
+	})
+	return res, ok
+}
+
+func (m *CopyOnWriteMap[K, V]) CompareAndSwap(key K, old, new V, eq func(a, b V) bool) bool {
+	if cur, ok := m.Get(key); !ok || !eq(cur, old) {
+		return false
+	}
+	swapped := false
+	m.write(func(next map[K]V) bool {
+		swapped = compareAndSwapEntry(next, key, old, new, eq)
+		return swapped
+	})
+	return swapped
+}
+
+func (m *CopyOnWriteMap[K, V]) All() iter.Seq2[K, V] {
+	return m.Range
+}
+
+// SetMany writes all entries with a single copy of the map
+func (m *CopyOnWriteMap[K, V]) SetMany(entries map[K]V) {
+	m.write(func(next map[K]V) bool {
+		for k, v := range entries {
+			next[k] = v
+		}
+		return true
+	})
+}
@@ -0,0 +1,127 @@
+package collections
+
+/*
+ __    _           ___
+|  |  |_|_____ ___|_  |
+|  |__| |     | .'|  _|
+|_____|_|_|_|_|__,|___|
+zed (14.03.2024)
+*/
+
+// ComputeAction tells Compute what to do with the value returned by the remapping func
+type ComputeAction int
+
+const (
+	// ComputeSet stores the returned value
+	ComputeSet ComputeAction = iota
+	// ComputeDelete removes the entry
+	ComputeDelete
+	// ComputeKeep leaves the map untouched
+	ComputeKeep
+)
+
+// computeLocks returns the per-key locks serializing Compute with Compute and with the load of the same key.
+// The load locks are used if loads are locked, otherwise the locks are created by the first Compute
+func (m *GuavaMap[K, V]) computeLocks() *MapMutex[K] {
+	if m.lockLoad != nil {
+		return m.lockLoad
+	}
+	m.computeLockOnce.Do(func() {
+		m.computeLock = NewMapMutex[K]()
+	})
+	return m.computeLock
+}
+
+// Compute atomically calls fn with the current value of the key and applies the returned action.
+// It returns the value associated with the key after the call and whether the key is present.
+// fn is called under the lock of the key only, so it may be slow, but it must not call the map.
+// fn is called again if the key is changed by Set, Delete or a load while fn runs
+func (m *GuavaMap[K, V]) Compute(key K, fn func(old V, exists bool) (V, ComputeAction)) (V, bool) {
+	locks := m.computeLocks()
+	locks.Lock(key)
+	defer locks.Unlock(key)
+	for {
+		var old V
+		var version uint64
+		m.mu.RLock()
+		h, exists := m.stored[key]
+		if exists {
+			old, version = h.v, h.version
+		}
+		m.mu.RUnlock()
+		v, action := fn(old, exists)
+		m.mu.Lock()
+		if cur := m.stored[key]; cur != h || (cur != nil && cur.version != version) {
+			m.unlock()
+			continue
+		}
+		v, ok := m.applyCompute(key, h, old, v, action)
+		m.unlock()
+		return v, ok
+	}
+}
+
+// applyCompute applies the action returned by the remapping func. Must be called under m.mu write lock
+func (m *GuavaMap[K, V]) applyCompute(key K, h *guavaHolder[V], old V, v V, action ComputeAction) (V, bool) {
+	switch action {
+	case ComputeSet:
+		m.setLocked(key, v)
+		m.propagate(key, v, false)
+		return v, true
+	case ComputeDelete:
+		m.safeDelete(key, RemovalExplicit)
+		var empty V
+		m.propagate(key, empty, true)
+		return empty, false
+	default:
+		if h != nil {
+			m.touch(h)
+		}
+		return old, h != nil
+	}
+}
+
+// ComputeIfAbsent returns the stored value or atomically stores the value returned by fn.
+// Nothing is stored if fn returns an error
+func (m *GuavaMap[K, V]) ComputeIfAbsent(key K, fn func(K) (V, error)) (V, error) {
+	var err error
+	v, _ := m.Compute(key, func(old V, exists bool) (V, ComputeAction) {
+		// the func is called again when the key changed, only the last call decides
+		err = nil
+		if exists {
+			return old, ComputeKeep
+		}
+		var v V
+		v, err = fn(key)
+		if err != nil {
+			return v, ComputeKeep
+		}
+		return v, ComputeSet
+	})
+	if err != nil {
+		var empty V
+		return empty, err
+	}
+	return v, nil
+}
+
+// ComputeIfPresent atomically remaps the stored value, the map is untouched if the key is absent
+func (m *GuavaMap[K, V]) ComputeIfPresent(key K, fn func(key K, old V) (V, ComputeAction)) (V, bool) {
+	return m.Compute(key, func(old V, exists bool) (V, ComputeAction) {
+		if !exists {
+			return old, ComputeKeep
+		}
+		return fn(key, old)
+	})
+}
+
+// Merge stores value if the key is absent, otherwise atomically stores the result of fn(old, value)
+func (m *GuavaMap[K, V]) Merge(key K, value V, fn func(old V, value V) V) V {
+	v, _ := m.Compute(key, func(old V, exists bool) (V, ComputeAction) {
+		if !exists {
+			return value, ComputeSet
+		}
+		return fn(old, value), ComputeSet
+	})
+	return v
+}
@@ -0,0 +1,123 @@
+package collections
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+)
+
+/*
+ __    _           ___
+|  |  |_|_____ ___|_  |
+|  |__| |     | .'|  _|
+|_____|_|_|_|_|__,|___|
+zed (14.03.2024)
+*/
+
+// InvalidationOp is the kind of change published to other replicas
+type InvalidationOp int
+
+const (
+	// InvalidationDelete - keys were deleted or invalidated
+	InvalidationDelete InvalidationOp = iota
+	// InvalidationUpdate - keys got new values, replicas drop their copies and load them again
+	InvalidationUpdate
+	// InvalidationClear - the whole map was cleared
+	InvalidationClear
+)
+
+// InvalidationMessage is sent between GuavaMap replicas, Origin identifies the publishing map
+type InvalidationMessage[K comparable] struct {
+	Origin string
+	Op     InvalidationOp
+	Keys   []K
+}
+
+// InvalidationTransport delivers invalidation messages between GuavaMap replicas.
+// Subscribe calls handler for every received message until ctx is done
+type InvalidationTransport[K comparable] interface {
+	Publish(msg InvalidationMessage[K]) error
+	Subscribe(ctx context.Context, handler func(InvalidationMessage[K])) error
+}
+
+func newInvalidationOrigin() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// invalidate queues the changed key for other replicas, it is published by unlock. Must be called under m.mu write lock
+func (m *GuavaMap[K, V]) invalidate(key K, op InvalidationOp) {
+	if m.bus == nil {
+		return
+	}
+	if op == InvalidationDelete {
+		m.busDeleted = append(m.busDeleted, key)
+	} else {
+		m.busUpdated = append(m.busUpdated, key)
+	}
+}
+
+func (m *GuavaMap[K, V]) publish(op InvalidationOp, keys []K) {
+	if err := m.bus.Publish(InvalidationMessage[K]{Origin: m.origin, Op: op, Keys: keys}); err != nil {
+		m.logger.Error().Err(err).Int("op", int(op)).Msg("failed to publish invalidation")
+	}
+}
+
+// applyInvalidation drops keys changed by other replicas without publishing or writing to L2
+func (m *GuavaMap[K, V]) applyInvalidation(msg InvalidationMessage[K]) {
+	if msg.Origin == m.origin {
+		return
+	}
+	m.mu.Lock()
+	defer m.unlock()
+	if msg.Op == InvalidationClear {
+		m.clearLocked()
+		return
+	}
+	for _, k := range msg.Keys {
+		m.safeDelete(k, RemovalExplicit)
+	}
+}
+
+// MemoryInvalidationBus is an in-process InvalidationTransport, every subscriber receives all published messages.
+// Useful for tests and for several maps of the same process
+type MemoryInvalidationBus[K comparable] struct {
+	mu       sync.RWMutex
+	handlers map[int]func(InvalidationMessage[K])
+	nextID   int
+}
+
+func NewMemoryInvalidationBus[K comparable]() *MemoryInvalidationBus[K] {
+	return &MemoryInvalidationBus[K]{
+		handlers: make(map[int]func(InvalidationMessage[K])),
+	}
+}
+
+func (b *MemoryInvalidationBus[K]) Publish(msg InvalidationMessage[K]) error {
+	b.mu.RLock()
+	handlers := make([]func(InvalidationMessage[K]), 0, len(b.handlers))
+	for _, h := range b.handlers {
+		handlers = append(handlers, h)
+	}
+	b.mu.RUnlock()
+	for _, h := range handlers {
+		h(msg)
+	}
+	return nil
+}
+
+func (b *MemoryInvalidationBus[K]) Subscribe(ctx context.Context, handler func(InvalidationMessage[K])) error {
+	b.mu.Lock()
+	id := b.nextID
+	b.nextID++
+	b.handlers[id] = handler
+	b.mu.Unlock()
+	context.AfterFunc(ctx, func() {
+		b.mu.Lock()
+		delete(b.handlers, id)
+		b.mu.Unlock()
+	})
+	return nil
+}
@@ -0,0 +1,111 @@
+package collections
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"testing"
+	"time"
+)
+
+/*
+ __    _           ___
+|  |  |_|_____ ___|_  |
+|  |__| |     | .'|  _|
+|_____|_|_|_|_|__,|___|
+zed (14.03.2024)
+*/
+
+func TestGuavaMap_MemoryInvalidation(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	bus := NewMemoryInvalidationBus[int]()
+	version := 1
+	loadFunc := func(key int) (int, error) {
+		return key*10 + version, nil
+	}
+	first := NewGuavaMap[int, int]().WithContext(ctx).WithLoadFunc(loadFunc).WithInvalidationTransport(bus).Build()
+	second := NewGuavaMap[int, int]().WithContext(ctx).WithLoadFunc(loadFunc).WithInvalidationTransport(bus).
+		WithConcurrencyLevel(4).BuildSegmented()
+
+	for i := 0; i < 10; i++ {
+		_, _ = first.Get(i)
+		_, _ = second.Get(i)
+	}
+	version = 2
+	first.Set(1, 12)
+	v, _ := first.Get(1)
+	assert.Equal(t, 12, v)
+	// the second replica dropped the stale value and loads it again
+	v, _ = second.Get(1)
+	assert.Equal(t, 12, v)
+
+	second.Delete(2)
+	assert.False(t, first.Has(2))
+	assert.Equal(t, 9, first.Size())
+
+	second.InvalidateIf(func(key int, value int) bool { return key > 5 })
+	assert.Equal(t, 5, first.Size())
+
+	first.Clear()
+	assert.Equal(t, 0, second.Size())
+}
+
+func TestTCPInvalidationTransport(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	a, err := NewTCPInvalidationTransport[string](ctx, "127.0.0.1:0")
+	assert.Nil(t, err)
+	b, err := NewTCPInvalidationTransport[string](ctx, "127.0.0.1:0", a.Addr())
+	assert.Nil(t, err)
+	a.AddPeer(b.Addr())
+
+	first := NewGuavaMap[string, string]().WithContext(ctx).WithInvalidationTransport(a).Build()
+	second := NewGuavaMap[string, string]().WithContext(ctx).WithInvalidationTransport(b).Build()
+	first.Set("k1", "a")
+	first.Set("k2", "a")
+	time.Sleep(time.Millisecond * 50)
+	second.Set("k1", "b")
+	second.Set("k2", "b")
+	time.Sleep(time.Millisecond * 50)
+	// every Set invalidated the copy of the other replica
+	assert.Equal(t, 0, first.Size())
+	assert.Equal(t, 2, second.Size())
+
+	first.Set("k3", "a")
+	second.Delete("k1")
+	time.Sleep(time.Millisecond * 50)
+	assert.Equal(t, 1, first.Size())
+	assert.Equal(t, 1, second.Size())
+	assert.True(t, second.Has("k2"))
+}
+
+func TestTCPInvalidationTransport_DeadPeer(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	dead, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	deadAddr := dead.Addr().String()
+	_ = dead.Close()
+
+	a, err := NewTCPInvalidationTransport[string](ctx, "127.0.0.1:0", deadAddr)
+	assert.Nil(t, err)
+	b, err := NewTCPInvalidationTransport[string](ctx, "127.0.0.1:0")
+	assert.Nil(t, err)
+	a.AddPeer(b.Addr())
+	received := make(chan InvalidationMessage[string], tcpInvalidationQueueSize*2)
+	assert.Nil(t, b.Subscribe(ctx, func(msg InvalidationMessage[string]) { received <- msg }))
+
+	start := time.Now()
+	var errs int
+	for i := 0; i < tcpInvalidationQueueSize+10; i++ {
+		if err := a.Publish(InvalidationMessage[string]{Op: InvalidationDelete, Keys: []string{"k"}}); err != nil {
+			assert.ErrorIs(t, err, ErrInvalidationQueueFull)
+			errs++
+		}
+	}
+	// the dead peer neither blocks Publish nor the delivery to the live peer
+	assert.Less(t, time.Since(start), time.Second)
+	assert.Greater(t, errs, 0)
+	assert.Eventually(t, func() bool { return len(received) > 0 }, 3*time.Second, 10*time.Millisecond)
+}
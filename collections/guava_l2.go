@@ -0,0 +1,192 @@
+package collections
+
+import (
+	"sync"
+	"time"
+)
+
+/*
+ __    _           ___
+|  |  |_|_____ ___|_  |
+|  |__| |     | .'|  _|
+|_____|_|_|_|_|__,|___|
+zed (14.03.2024)
+*/
+
+// L2Store is a second level storage behind GuavaMap, usually shared between several processes.
+// Get returns false if the key is absent or expired, ttl 0 means the value never expires
+type L2Store[K comparable, V any] interface {
+	Get(key K) (V, bool, error)
+	Set(key K, value V, ttl time.Duration) error
+	Delete(key K) error
+}
+
+// L2Mode describes how GuavaMap works with its L2Store
+type L2Mode int
+
+const (
+	// L2ReadThrough - misses are looked up in L2 before the load func, loaded values are stored to L2.
+	// Set and Delete only change the local map
+	L2ReadThrough L2Mode = iota
+	// L2WriteThrough - like L2ReadThrough, Set, Delete and invalidations are also applied to L2 before the call returns
+	L2WriteThrough
+	// L2WriteBehind - like L2WriteThrough, but L2 is updated by a background writer in the order of changes.
+	// The writer publishes the invalidation of a change after it is written to L2
+	L2WriteBehind
+)
+
+const defaultL2QueueSize = 1024
+
+const l2VersionStripes = 64
+
+type l2Op[K comparable, V any] struct {
+	key    K
+	value  V
+	delete bool
+	seq    uint64
+	// publish makes the writer publish the invalidation after the write
+	publish bool
+}
+
+// l2Versions orders the L2 writes of a key. Every change gets a sequence number under the map lock,
+// a write is applied only if it is still the latest change of its key, so a stale write applied late can't
+// overwrite a newer one. The check and the write are done under the stripe lock of the key
+type l2Versions[K comparable] struct {
+	mu      sync.Mutex
+	seq     uint64
+	latest  map[K]uint64
+	stripes [l2VersionStripes]sync.Mutex
+	hash    func(K) uint64
+}
+
+func newL2Versions[K comparable]() *l2Versions[K] {
+	return &l2Versions[K]{latest: make(map[K]uint64), hash: newKeyHasher[K]()}
+}
+
+// next returns the sequence number of a new change of key
+func (v *l2Versions[K]) next(key K) uint64 {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.seq++
+	v.latest[key] = v.seq
+	return v.seq
+}
+
+// apply calls fn if seq is the latest change of key
+func (v *l2Versions[K]) apply(key K, seq uint64, fn func()) {
+	stripe := &v.stripes[v.hash(key)%l2VersionStripes]
+	stripe.Lock()
+	defer stripe.Unlock()
+	v.mu.Lock()
+	latest := v.latest[key]
+	v.mu.Unlock()
+	if latest != seq {
+		return
+	}
+	fn()
+	v.mu.Lock()
+	if v.latest[key] == seq {
+		delete(v.latest, key)
+	}
+	v.mu.Unlock()
+}
+
+// l2TTL is the lifetime of values written to L2, write timeout if set, otherwise read timeout
+func (m *GuavaMap[K, V]) l2TTL() time.Duration {
+	if m.writeTimeout > 0 {
+		return m.writeTimeout
+	}
+	return m.readTimeout
+}
+
+// loadThrough looks the key up in L2 and falls back to the load func.
+// It returns false if neither of them has the value. The value loaded by the func is written to L2 by Get
+// when it is stored to the map, so it is ordered with the other changes of the key
+func (m *GuavaMap[K, V]) loadThrough(key K) (v V, loaded bool, fromFunc bool, err error) {
+	if m.l2 != nil {
+		v, ok, err := m.l2.Get(key)
+		if err != nil {
+			m.logger.Error().Err(err).Interface("key", key).Msg("failed to get value from l2")
+		} else if ok {
+			return v, true, false, nil
+		}
+	}
+	if m.loadFunc == nil {
+		return v, false, false, nil
+	}
+	if v, err = m.load(key); err != nil {
+		return v, false, false, err
+	}
+	return v, true, true, nil
+}
+
+// propagate queues the change for L2 and other replicas, it is applied by unlock. Must be called under m.mu write lock
+func (m *GuavaMap[K, V]) propagate(key K, value V, delete bool) {
+	// with write behind replicas are invalidated by the writer, otherwise they could reload the old value from L2
+	writeBehind := m.l2 != nil && m.l2Mode == L2WriteBehind
+	if !writeBehind {
+		m.invalidate(key, invalidationOp(delete))
+	}
+	if m.l2 == nil || m.l2Mode == L2ReadThrough {
+		return
+	}
+	m.queueL2(l2Op[K, V]{key: key, value: value, delete: delete, publish: writeBehind && m.bus != nil})
+}
+
+func invalidationOp(delete bool) InvalidationOp {
+	if delete {
+		return InvalidationDelete
+	}
+	return InvalidationUpdate
+}
+
+// queueL2 numbers the change and queues it for L2, it is applied by unlock. Must be called under m.mu write lock
+func (m *GuavaMap[K, V]) queueL2(op l2Op[K, V]) {
+	op.seq = m.l2Versions.next(op.key)
+	m.l2Pending = append(m.l2Pending, op)
+}
+
+func (m *GuavaMap[K, V]) writeL2(ops []l2Op[K, V]) {
+	if m.l2Mode == L2WriteBehind {
+		for _, op := range ops {
+			select {
+			case m.l2Queue <- op:
+			case <-m.ctx.Done():
+				return
+			}
+		}
+		return
+	}
+	for _, op := range ops {
+		m.applyL2(op)
+	}
+}
+
+// applyL2 writes the change to L2, unless the key is changed again after it
+func (m *GuavaMap[K, V]) applyL2(op l2Op[K, V]) {
+	m.l2Versions.apply(op.key, op.seq, func() {
+		var err error
+		if op.delete {
+			err = m.l2.Delete(op.key)
+		} else {
+			err = m.l2.Set(op.key, op.value, m.l2TTL())
+		}
+		if err != nil {
+			m.logger.Error().Err(err).Interface("key", op.key).Bool("delete", op.delete).Msg("failed to write value to l2")
+		}
+	})
+}
+
+func (m *GuavaMap[K, V]) l2Writer(queue chan l2Op[K, V]) {
+	for {
+		select {
+		case <-m.ctx.Done():
+			return
+		case op := <-queue:
+			m.applyL2(op)
+			if op.publish {
+				m.publish(invalidationOp(op.delete), []K{op.key})
+			}
+		}
+	}
+}
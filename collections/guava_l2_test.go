@@ -0,0 +1,252 @@
+package collections
+
+import (
+	"context"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"math/rand/v2"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+/*
+ __    _           ___
+|  |  |_|_____ ___|_  |
+|  |__| |     | .'|  _|
+|_____|_|_|_|_|__,|___|
+zed (14.03.2024)
+*/
+
+func TestFileL2Store(t *testing.T) {
+	s, err := NewFileL2Store[string, int](t.TempDir())
+	assert.Nil(t, err)
+	_, ok, err := s.Get("a")
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	assert.Nil(t, s.Set("a", 1, 0))
+	assert.Nil(t, s.Set("b", 2, time.Millisecond*10))
+	v, ok, err := s.Get("a")
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, 1, v)
+
+	time.Sleep(time.Millisecond * 20)
+	_, ok, err = s.Get("b")
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	assert.Nil(t, s.Set("c", 3, time.Millisecond*10))
+	time.Sleep(time.Millisecond * 20)
+	assert.Nil(t, s.RemoveExpired())
+	_, ok, _ = s.Get("c")
+	assert.False(t, ok)
+
+	assert.Nil(t, s.Delete("a"))
+	assert.Nil(t, s.Delete("a"))
+	_, ok, _ = s.Get("a")
+	assert.False(t, ok)
+}
+
+func TestGuavaMap_L2WriteThrough(t *testing.T) {
+	store, err := NewFileL2Store[int, int](t.TempDir())
+	assert.Nil(t, err)
+	loads := int32(0)
+	loadFunc := func(key int) (int, error) {
+		atomic.AddInt32(&loads, 1)
+		return key * 10, nil
+	}
+	first := NewGuavaMap[int, int]().WithLoadFunc(loadFunc).WithL2(store, L2WriteThrough).Build()
+	second := NewGuavaMap[int, int]().WithLoadFunc(loadFunc).WithL2(store, L2WriteThrough).Build()
+
+	v, err := first.Get(1)
+	assert.Nil(t, err)
+	assert.Equal(t, 10, v)
+	// loaded by the first map, taken from l2 by the second
+	v, err = second.Get(1)
+	assert.Nil(t, err)
+	assert.Equal(t, 10, v)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&loads))
+
+	first.Set(2, 200)
+	v, err = second.Get(2)
+	assert.Nil(t, err)
+	assert.Equal(t, 200, v)
+
+	first.Delete(1)
+	_, ok, err := store.Get(1)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	first.Merge(2, 1, func(old int, value int) int { return old + value })
+	v, _, _ = store.Get(2)
+	assert.Equal(t, 201, v)
+}
+
+func TestGuavaMap_L2Modes(t *testing.T) {
+	store, err := NewFileL2Store[string, string](t.TempDir())
+	assert.Nil(t, err)
+	readThrough := NewGuavaMap[string, string]().WithL2(store, L2ReadThrough).Build()
+	readThrough.Set("local", "v")
+	_, ok, _ := store.Get("local")
+	assert.False(t, ok)
+	assert.Nil(t, store.Set("shared", "s", 0))
+	v, err := readThrough.Get("shared")
+	assert.Nil(t, err)
+	assert.Equal(t, "s", v)
+	v, err = readThrough.Get("missing")
+	assert.Nil(t, err)
+	assert.Equal(t, "", v)
+	assert.Equal(t, 2, readThrough.Size())
+
+	writeBehind := NewGuavaMap[string, string]().WithL2(store, L2WriteBehind).WithConcurrencyLevel(4).BuildSegmented()
+	for i := 0; i < 10; i++ {
+		writeBehind.Set("key", string(rune('a'+i)))
+	}
+	time.Sleep(time.Millisecond * 50)
+	v, ok, _ = store.Get("key")
+	assert.True(t, ok)
+	assert.Equal(t, "j", v)
+}
+
+// slowL2Store delays every write by a random time, so concurrent writes reach it out of order
+type slowL2Store[K comparable, V any] struct {
+	mu     sync.Mutex
+	values map[K]V
+}
+
+func (s *slowL2Store[K, V]) Get(key K) (V, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v, ok := s.values[key]
+	return v, ok, nil
+}
+
+func (s *slowL2Store[K, V]) Set(key K, value V, ttl time.Duration) error {
+	time.Sleep(time.Duration(rand.IntN(500)) * time.Microsecond)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.values[key] = value
+	return nil
+}
+
+func (s *slowL2Store[K, V]) Delete(key K) error {
+	time.Sleep(time.Duration(rand.IntN(500)) * time.Microsecond)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.values, key)
+	return nil
+}
+
+func TestGuavaMap_L2WriteOrder(t *testing.T) {
+	store := &slowL2Store[string, int]{values: make(map[string]int)}
+	m := NewGuavaMap[string, int]().WithL2(store, L2WriteThrough).Build()
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			m.Set("key", i)
+		}()
+	}
+	wg.Wait()
+	// l2 keeps the last value of the map, not the last written one
+	local, _ := m.Get("key")
+	v, ok, _ := store.Get("key")
+	assert.True(t, ok)
+	assert.Equal(t, local, v)
+	assert.Empty(t, m.l2Versions.latest)
+}
+
+// delayedL2Store delays every write, so a reader sees the old value until the write completes
+type delayedL2Store[K comparable, V any] struct {
+	slowL2Store[K, V]
+	delay time.Duration
+}
+
+func (s *delayedL2Store[K, V]) Set(key K, value V, ttl time.Duration) error {
+	time.Sleep(s.delay)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.values[key] = value
+	return nil
+}
+
+func TestGuavaMap_L2WriteBehindInvalidation(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	store := &delayedL2Store[int, int]{slowL2Store: slowL2Store[int, int]{values: map[int]int{1: 1}}, delay: 10 * time.Millisecond}
+	bus := NewMemoryInvalidationBus[int]()
+	first := NewGuavaMap[int, int]().WithContext(ctx).WithL2(store, L2WriteBehind).WithInvalidationTransport(bus).Build()
+	second := NewGuavaMap[int, int]().WithContext(ctx).WithL2(store, L2ReadThrough).WithInvalidationTransport(bus).Build()
+	v, _ := second.Get(1)
+	assert.Equal(t, 1, v)
+
+	published := make(chan int, 1)
+	_ = bus.Subscribe(ctx, func(msg InvalidationMessage[int]) {
+		v, _, _ := store.Get(1)
+		published <- v
+	})
+	first.Set(1, 2)
+	// the invalidation is published after the write, the replica reloads the new value from l2
+	select {
+	case v = <-published:
+		assert.Equal(t, 2, v)
+	case <-time.After(time.Second):
+		t.Fatal("invalidation was not published")
+	}
+	assert.Eventually(t, func() bool { return !second.Has(1) }, time.Second, time.Millisecond)
+	v, _ = second.Get(1)
+	assert.Equal(t, 2, v)
+}
+
+func TestFileL2Store_KeyEncoder(t *testing.T) {
+	type ptrKey struct {
+		id   int
+		name *string
+	}
+	_, err := NewFileL2Store[ptrKey, int](t.TempDir())
+	assert.ErrorIs(t, err, ErrL2KeyNotEncodable)
+
+	dir := t.TempDir()
+	encode := func(k ptrKey) string { return fmt.Sprintf("%d/%s", k.id, *k.name) }
+	first, err := NewFileL2StoreWithKeyEncoder[ptrKey, int](dir, encode)
+	assert.Nil(t, err)
+	second, err := NewFileL2StoreWithKeyEncoder[ptrKey, int](dir, encode)
+	assert.Nil(t, err)
+	a, b := "a", "a"
+	assert.Nil(t, first.Set(ptrKey{id: 1, name: &a}, 1, 0))
+	// equal keys with different pointers share the file
+	v, ok, err := second.Get(ptrKey{id: 1, name: &b})
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, 1, v)
+
+	_, err = NewFileL2Store[time.Time, int](t.TempDir())
+	assert.Nil(t, err)
+}
+
+func TestFileL2Store_ExpiredKeptByGet(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewFileL2Store[string, int](dir)
+	assert.Nil(t, err)
+	assert.Nil(t, s.Set("a", 1, time.Millisecond))
+	assert.Nil(t, s.Set("b", 2, time.Millisecond))
+	time.Sleep(time.Millisecond * 5)
+	_, ok, err := s.Get("a")
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	files, _ := os.ReadDir(dir)
+	assert.Len(t, files, 2)
+
+	assert.Nil(t, s.Set("b", 3, 0))
+	assert.Nil(t, s.RemoveExpired())
+	files, _ = os.ReadDir(dir)
+	assert.Len(t, files, 1)
+	v, ok, _ := s.Get("b")
+	assert.True(t, ok)
+	assert.Equal(t, 3, v)
+}
@@ -2,7 +2,10 @@ package collections
 
 import (
 	"context"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -17,15 +20,52 @@ zed (14.03.2024)
 type GuavaLoadFunc[K comparable, V any] func(K) (V, error)
 type GuavaUnloadFunc[K comparable, V any] func(K, V)
 
+// RemovalCause describes why an entry was removed from the GuavaMap
+type RemovalCause int
+
+const (
+	// RemovalExplicit - entry was removed by Delete or Clear
+	RemovalExplicit RemovalCause = iota
+	// RemovalSize - entry was evicted because the map reached maxCount
+	RemovalSize
+	// RemovalReadTimeout - entry was not read during readTimeout
+	RemovalReadTimeout
+	// RemovalWriteTimeout - entry was not written during writeTimeout
+	RemovalWriteTimeout
+	// RemovalReplaced - entry value was overwritten by Set or LockForUpdate
+	RemovalReplaced
+)
+
+func (c RemovalCause) String() string {
+	switch c {
+	case RemovalExplicit:
+		return "explicit"
+	case RemovalSize:
+		return "size"
+	case RemovalReadTimeout:
+		return "read_timeout"
+	case RemovalWriteTimeout:
+		return "write_timeout"
+	case RemovalReplaced:
+		return "replaced"
+	default:
+		return "unknown"
+	}
+}
+
 type timeoutHolder[K comparable] struct {
 	key  K
 	time time.Time
 }
 
 type guavaHolder[V any] struct {
-	timer    *time.Timer
-	cancelFn context.CancelFunc
-	v        V
+	timer      *time.Timer
+	writtenAt  atomic.Int64
+	accessedAt atomic.Int64
+	cancelFn   context.CancelFunc
+	v          V
+	// version is increased by every write of v in place, so Compute can detect the changes made while its func runs
+	version uint64
 }
 
 type GuavaMap[K comparable, V any] struct {
@@ -36,11 +76,28 @@ type GuavaMap[K comparable, V any] struct {
 	mu                 sync.RWMutex
 	maxCount           int
 	loadFunc           GuavaLoadFunc[K, V]
-	unloadFunc         GuavaUnloadFunc[K, V]
+	listeners          []GuavaRemovalListener[K, V]
+	listenerMode       guavaListenerMode
+	listenerQueue      chan RemovalNotification[K, V]
+	pending            []RemovalNotification[K, V]
 	enableWriteTimeout bool
 	writeTimeout       time.Duration
 	readTimeout        time.Duration
 	lockLoad           *MapMutex[K]
+	computeLock        *MapMutex[K]
+	computeLockOnce    sync.Once
+	stats              *guavaStatsCounter
+	codec              GuavaSnapshotCodec[K, V]
+	l2                 L2Store[K, V]
+	l2Mode             L2Mode
+	l2Queue            chan l2Op[K, V]
+	l2Pending          []l2Op[K, V]
+	l2Versions         *l2Versions[K]
+	bus                InvalidationTransport[K]
+	origin             string
+	busDeleted         []K
+	busUpdated         []K
+	logger             zerolog.Logger
 	ctx                context.Context
 }
 
@@ -56,27 +113,85 @@ func (m *GuavaMap[K, V]) getKeyLock(key K) *sync.Mutex {
 }
 
 func (m *GuavaMap[K, V]) createHolder(key K, value V) *guavaHolder[V] {
+	now := time.Now().UnixNano()
+	return m.createHolderAt(key, value, now, now)
+}
+
+// createHolderWithTTL creates an entry which expires after ttl.
+// Write and access times are shifted back, so the nearest of the timeouts ends after ttl
+func (m *GuavaMap[K, V]) createHolderWithTTL(key K, value V, ttl time.Duration) *guavaHolder[V] {
+	deadline := time.Now().Add(ttl).UnixNano()
+	return m.createHolderAt(key, value, deadline-int64(m.writeTimeout), deadline-int64(m.readTimeout))
+}
+
+// expireAt returns the time the entry expires, zero time if it never expires.
+// The entry expires writeTimeout after the last write or readTimeout after the last access, whichever comes first
+func (m *GuavaMap[K, V]) expireAt(h *guavaHolder[V]) time.Time {
+	if h.timer == nil {
+		return time.Time{}
+	}
+	var deadline int64
+	if m.writeTimeout > 0 {
+		deadline = h.writtenAt.Load() + int64(m.writeTimeout)
+	}
+	if m.readTimeout > 0 {
+		if accessDeadline := h.accessedAt.Load() + int64(m.readTimeout); deadline == 0 || accessDeadline < deadline {
+			deadline = accessDeadline
+		}
+	}
+	return time.Unix(0, deadline)
+}
+
+// touch postpones read expiration of the entry. It only stores the access time,
+// so it is safe under the read lock; the expiration goroutine re-arms its timer when it fires too early
+func (m *GuavaMap[K, V]) touch(h *guavaHolder[V]) {
+	if h.timer != nil && m.readTimeout > 0 {
+		h.accessedAt.Store(time.Now().UnixNano())
+	}
+}
+
+// written postpones both expirations of the entry. Must be called under m.mu write lock
+func (m *GuavaMap[K, V]) written(h *guavaHolder[V]) {
+	if h.timer != nil {
+		now := time.Now().UnixNano()
+		h.writtenAt.Store(now)
+		h.accessedAt.Store(now)
+	}
+}
+
+func (m *GuavaMap[K, V]) createHolderAt(key K, value V, writtenAt, accessedAt int64) *guavaHolder[V] {
 	res := &guavaHolder[V]{
 		v: value,
 	}
 	if m.readTimeout > 0 || m.writeTimeout > 0 {
 		ctx, cancelFn := context.WithCancel(m.ctx)
 		res.cancelFn = cancelFn
-		if m.writeTimeout > 0 {
-			res.timer = time.NewTimer(m.writeTimeout)
-		} else {
-			res.timer = time.NewTimer(m.readTimeout)
-		}
+		res.writtenAt.Store(writtenAt)
+		res.accessedAt.Store(accessedAt)
+		res.timer = time.NewTimer(time.Until(m.expireAt(res)))
 		go func() {
 			defer res.timer.Stop()
-			select {
-			case <-ctx.Done():
-				return
-			case <-res.timer.C:
-				m.mu.Lock()
-				m.safeDelete(key)
-				m.mu.Unlock()
-
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-res.timer.C:
+					m.mu.Lock()
+					// the key may have been deleted and stored again while we were waiting for the lock
+					if m.stored[key] != res {
+						m.unlock()
+						return
+					}
+					now := time.Now()
+					if left := m.expireAt(res).Sub(now); left > 0 {
+						res.timer.Reset(left)
+						m.unlock()
+						continue
+					}
+					m.safeDelete(key, m.timeoutCause(res, now))
+					m.unlock()
+					return
+				}
 			}
 		}()
 	}
@@ -88,9 +203,7 @@ func (m *GuavaMap[K, V]) Has(key K) bool {
 	defer m.mu.RUnlock()
 	v, ok := m.stored[key]
 	if ok {
-		if v.timer != nil && m.readTimeout > 0 {
-			v.timer.Reset(m.readTimeout)
-		}
+		m.touch(v)
 	}
 	return ok
 }
@@ -98,34 +211,59 @@ func (m *GuavaMap[K, V]) Has(key K) bool {
 // HasOrCreate checks if the key exists in the map, if not, it creates a new entry with the provided value and returns false
 func (m *GuavaMap[K, V]) HasOrCreate(key K, value V) bool {
 	m.mu.Lock()
-	defer m.mu.Unlock()
+	defer m.unlock()
 	_, ok := m.stored[key]
 	if !ok {
-		m.stored[key] = m.createHolder(key, value)
-		if m.maxCount > 0 {
-			if len(m.storedSlice) >= m.maxCount {
-				m.safeDelete(m.storedSlice[0])
-			}
-		}
-		m.storedSlice = append(m.storedSlice, key)
+		m.insert(key, value)
+		m.propagate(key, value, false)
 	} else {
-		if m.stored[key].timer != nil && m.readTimeout > 0 {
-			m.stored[key].timer.Reset(m.readTimeout)
-		}
+		m.touch(m.stored[key])
 	}
 	return ok
 }
 
-func (m *GuavaMap[K, V]) safeDelete(key K) {
-	if m.unloadFunc != nil {
-		v := m.stored[key].v
-		go m.unloadFunc(key, v)
+func (m *GuavaMap[K, V]) timeoutCause(h *guavaHolder[V], now time.Time) RemovalCause {
+	if m.writeTimeout > 0 && now.UnixNano() >= h.writtenAt.Load()+int64(m.writeTimeout) {
+		return RemovalWriteTimeout
 	}
-	if m.readTimeout > 0 || m.writeTimeout > 0 {
-		v := m.stored[key]
-		if v != nil && v.cancelFn != nil {
-			v.cancelFn()
+	return RemovalReadTimeout
+}
+
+// insert stores a new entry, evicting the oldest one if the map is full. Must be called under m.mu write lock
+func (m *GuavaMap[K, V]) insert(key K, value V) {
+	m.insertHolder(key, m.createHolder(key, value))
+}
+
+func (m *GuavaMap[K, V]) insertHolder(key K, h *guavaHolder[V]) {
+	if m.maxCount > 0 {
+		if len(m.storedSlice) >= m.maxCount {
+			m.safeDelete(m.storedSlice[0], RemovalSize)
 		}
+		m.storedSlice = append(m.storedSlice, key)
+	}
+	m.stored[key] = h
+}
+
+// replace swaps the entry holder keeping its position in the eviction order. Must be called under m.mu write lock
+func (m *GuavaMap[K, V]) replace(key K, old *guavaHolder[V], value V) {
+	if old.cancelFn != nil {
+		old.cancelFn()
+	}
+	m.notify(key, old.v, RemovalReplaced)
+	m.stored[key] = m.createHolder(key, value)
+}
+
+func (m *GuavaMap[K, V]) safeDelete(key K, cause RemovalCause) {
+	h, ok := m.stored[key]
+	if !ok {
+		return
+	}
+	if m.stats != nil {
+		m.stats.recordRemoval(cause, 1)
+	}
+	m.notify(key, h.v, cause)
+	if h.cancelFn != nil {
+		h.cancelFn()
 	}
 
 	delete(m.stored, key)
@@ -177,26 +315,40 @@ func (m *GuavaMap[K, V]) LockForUpdate(key K, update func() V) V {
 	defer lock.Unlock()
 	res := update()
 	m.mu.Lock()
-	defer m.mu.Unlock()
-	m.stored[key] = m.createHolder(key, res)
+	defer m.unlock()
+	if old, exists := m.stored[key]; exists {
+		m.replace(key, old, res)
+	} else {
+		m.insert(key, res)
+	}
+	m.propagate(key, res, false)
 	return res
 }
 
 func (m *GuavaMap[K, V]) Delete(key K) {
 	m.mu.Lock()
-	defer m.mu.Unlock()
-	m.safeDelete(key)
+	defer m.unlock()
+	m.safeDelete(key, RemovalExplicit)
+	m.propagate(key, *new(V), true)
 }
 
 func (m *GuavaMap[K, V]) Clear() {
 	m.mu.Lock()
-	defer m.mu.Unlock()
-	if m.unloadFunc != nil {
-		for k, v := range m.stored {
-			go m.unloadFunc(k, v.v)
-			if v.cancelFn != nil {
-				v.cancelFn()
-			}
+	m.clearLocked()
+	m.unlock()
+	if m.bus != nil {
+		m.publish(InvalidationClear, nil)
+	}
+}
+
+func (m *GuavaMap[K, V]) clearLocked() {
+	if m.stats != nil {
+		m.stats.recordRemoval(RemovalExplicit, uint64(len(m.stored)))
+	}
+	for k, v := range m.stored {
+		m.notify(k, v.v, RemovalExplicit)
+		if v.cancelFn != nil {
+			v.cancelFn()
 		}
 	}
 	m.stored = make(map[K]*guavaHolder[V])
@@ -221,26 +373,32 @@ func (m *GuavaMap[K, V]) Get(key K) (V, error) {
 	val, ok := m.stored[key]
 	m.mu.RUnlock()
 	if ok {
-		if val.timer != nil && m.readTimeout > 0 {
-			val.timer.Reset(m.readTimeout)
+		if m.stats != nil {
+			m.stats.hits.Add(1)
 		}
+		m.touch(val)
 		return val.v, nil
 	}
-	if m.loadFunc == nil {
+	if m.stats != nil {
+		m.stats.misses.Add(1)
+	}
+	if m.loadFunc == nil && m.l2 == nil {
 		var v V
 		return v, nil
 	}
 	var err error
 	var loadedVal V
+	var loaded, fromFunc bool
 
 	if m.lockLoad != nil {
+		// hold the key until the loaded value is stored, so Compute can't run in between
 		m.lockLoad.Lock(key)
+		defer m.lockLoad.Unlock(key)
 		if !m.Has(key) {
-			loadedVal, err = m.loadFunc(key)
+			loadedVal, loaded, fromFunc, err = m.loadThrough(key)
 		}
-		m.lockLoad.Unlock(key)
 	} else {
-		loadedVal, err = m.loadFunc(key)
+		loadedVal, loaded, fromFunc, err = m.loadThrough(key)
 	}
 
 	if err != nil {
@@ -248,59 +406,125 @@ func (m *GuavaMap[K, V]) Get(key K) (V, error) {
 		return v, err
 	}
 	m.mu.Lock()
-	defer m.mu.Unlock()
+	defer m.unlock()
 	val, ok = m.stored[key]
 	if ok {
-		if val.timer != nil && m.readTimeout > 0 {
-			val.timer.Reset(m.readTimeout)
-		}
+		m.touch(val)
 		return val.v, nil
 	}
-	if m.maxCount > 0 {
-		if len(m.storedSlice) >= m.maxCount {
-			m.safeDelete(m.storedSlice[0])
-		}
-		m.storedSlice = append(m.storedSlice, key)
+	if !loaded {
+		var v V
+		return v, nil
+	}
+	m.insert(key, loadedVal)
+	if fromFunc && m.l2 != nil {
+		m.queueL2(l2Op[K, V]{key: key, value: loadedVal})
 	}
-	m.stored[key] = m.createHolder(key, loadedVal)
 	return loadedVal, nil
 }
 
+func (m *GuavaMap[K, V]) load(key K) (V, error) {
+	if m.stats == nil {
+		return m.loadFunc(key)
+	}
+	start := time.Now()
+	v, err := m.loadFunc(key)
+	m.stats.recordLoad(time.Since(start), err)
+	return v, err
+}
+
+// Stats returns a snapshot of the map counters, empty if the map was built without WithRecordStats
+func (m *GuavaMap[K, V]) Stats() GuavaStats {
+	if m.stats == nil {
+		return GuavaStats{}
+	}
+	return m.stats.snapshot()
+}
+
 func (m *GuavaMap[K, V]) GetStored() map[K]V {
-	resStored := make(map[K]V, len(m.stored))
 	m.mu.RLock()
+	defer m.mu.RUnlock()
+	resStored := make(map[K]V, len(m.stored))
 	for k, v := range m.stored {
 		resStored[k] = v.v
 	}
-	m.mu.RUnlock()
 	return resStored
 }
 
+// Range calls fn for every stored entry until fn returns false.
+// Entries are copied under the lock, so fn may call the map; changes made during Range may not be seen
+func (m *GuavaMap[K, V]) Range(fn func(key K, value V) bool) {
+	m.mu.RLock()
+	keys := make([]K, 0, len(m.stored))
+	values := make([]V, 0, len(m.stored))
+	for k, v := range m.stored {
+		keys = append(keys, k)
+		values = append(values, v.v)
+	}
+	m.mu.RUnlock()
+	for i, k := range keys {
+		if !fn(k, values[i]) {
+			return
+		}
+	}
+}
+
+func (m *GuavaMap[K, V]) Keys() []K {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	keys := make([]K, 0, len(m.stored))
+	for k := range m.stored {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// InvalidateAll removes the keys from the map
+func (m *GuavaMap[K, V]) InvalidateAll(keys []K) {
+	m.mu.Lock()
+	defer m.unlock()
+	for _, k := range keys {
+		m.safeDelete(k, RemovalExplicit)
+		m.propagate(k, *new(V), true)
+	}
+}
+
+// InvalidateIf removes all entries matching the predicate and returns the number of removed entries.
+// fn is called under the map lock and must not call the map
+func (m *GuavaMap[K, V]) InvalidateIf(fn func(key K, value V) bool) int {
+	m.mu.Lock()
+	defer m.unlock()
+	var matched []K
+	for k, v := range m.stored {
+		if fn(k, v.v) {
+			matched = append(matched, k)
+		}
+	}
+	for _, k := range matched {
+		m.safeDelete(k, RemovalExplicit)
+		m.propagate(k, *new(V), true)
+	}
+	return len(matched)
+}
+
 func (m *GuavaMap[K, V]) Set(key K, val V) {
 	m.mu.Lock()
-	defer m.mu.Unlock()
+	defer m.unlock()
+	m.setLocked(key, val)
+	m.propagate(key, val, false)
+}
+
+// setLocked stores or replaces the value. Must be called under m.mu write lock
+func (m *GuavaMap[K, V]) setLocked(key K, val V) {
 	v, ok := m.stored[key]
 	if !ok {
-		m.stored[key] = m.createHolder(key, val)
-		if m.maxCount > 0 {
-			if len(m.storedSlice) >= m.maxCount {
-				m.safeDelete(m.storedSlice[0])
-			}
-			m.storedSlice = append(m.storedSlice, key)
-		}
-		return
-	} else {
-		if v.timer != nil {
-			if m.writeTimeout > 0 {
-				v.timer.Reset(m.writeTimeout)
-			} else {
-				v.timer.Reset(m.readTimeout)
-			}
-		}
-		v.v = val
+		m.insert(key, val)
 		return
 	}
-
+	m.notify(key, v.v, RemovalReplaced)
+	m.written(v)
+	v.v = val
+	v.version++
 }
 
 type GuavaMapBuilder[K comparable, V any] struct {
@@ -308,14 +532,27 @@ type GuavaMapBuilder[K comparable, V any] struct {
 	lockLoad     bool
 	maxCount     int
 	unloadFunc   GuavaUnloadFunc[K, V]
+	listener     GuavaRemovalListener[K, V]
+	listenerMode guavaListenerMode
+	workers      int
+	queueSize    int
 	writeTimeout time.Duration
 	readTimeout  time.Duration
+	recordStats  bool
+	codec        GuavaSnapshotCodec[K, V]
+	concurrency  int
+	l2           L2Store[K, V]
+	l2Mode       L2Mode
+	bus          InvalidationTransport[K]
+	logger       zerolog.Logger
 	ctx          context.Context
 }
 
 func NewGuavaMap[K comparable, V any]() *GuavaMapBuilder[K, V] {
 	return &GuavaMapBuilder[K, V]{
-		ctx: context.Background(),
+		ctx:    context.Background(),
+		codec:  GobSnapshotCodec[K, V]{},
+		logger: log.Logger,
 	}
 }
 
@@ -344,6 +581,29 @@ func (b *GuavaMapBuilder[K, V]) WithUnloadFunc(unloadFunc GuavaUnloadFunc[K, V])
 	return b
 }
 
+// WithRemovalListener sets the listener called for every removed or replaced entry
+func (b *GuavaMapBuilder[K, V]) WithRemovalListener(listener GuavaRemovalListener[K, V]) *GuavaMapBuilder[K, V] {
+	b.listener = listener
+	return b
+}
+
+// WithSyncRemovalListener makes listeners run on the goroutine which removed the entry, after the map lock is released.
+// Notifications of one call are delivered in order, concurrent removals of the same key may be delivered in any order
+func (b *GuavaMapBuilder[K, V]) WithSyncRemovalListener() *GuavaMapBuilder[K, V] {
+	b.listenerMode = guavaListenerSync
+	return b
+}
+
+// WithRemovalExecutor makes listeners run on a pool of workers fed by a queue of queueSize notifications.
+// Removals block when the queue is full. With a single worker notifications of one call are delivered in order,
+// notifications of concurrent removals are queued after the map lock is released and may be delivered in any order
+func (b *GuavaMapBuilder[K, V]) WithRemovalExecutor(workers int, queueSize int) *GuavaMapBuilder[K, V] {
+	b.listenerMode = guavaListenerExecutor
+	b.workers = workers
+	b.queueSize = queueSize
+	return b
+}
+
 func (b *GuavaMapBuilder[K, V]) WithWriteTimeout(writeTimeout time.Duration) *GuavaMapBuilder[K, V] {
 	b.writeTimeout = writeTimeout
 	return b
@@ -354,21 +614,111 @@ func (b *GuavaMapBuilder[K, V]) WithReadTimeout(readTimeout time.Duration) *Guav
 	return b
 }
 
+// WithRecordStats enables hit, load and eviction counters returned by GuavaMap.Stats
+func (b *GuavaMapBuilder[K, V]) WithRecordStats() *GuavaMapBuilder[K, V] {
+	b.recordStats = true
+	return b
+}
+
+// WithSnapshotCodec sets the codec used by SaveSnapshot and LoadSnapshot, gob by default
+func (b *GuavaMapBuilder[K, V]) WithSnapshotCodec(codec GuavaSnapshotCodec[K, V]) *GuavaMapBuilder[K, V] {
+	b.codec = codec
+	return b
+}
+
+// WithL2 puts the map in front of the second level store
+func (b *GuavaMapBuilder[K, V]) WithL2(store L2Store[K, V], mode L2Mode) *GuavaMapBuilder[K, V] {
+	b.l2 = store
+	b.l2Mode = mode
+	return b
+}
+
+// WithInvalidationTransport makes the map publish its changes to other replicas and drop keys changed by them
+func (b *GuavaMapBuilder[K, V]) WithInvalidationTransport(bus InvalidationTransport[K]) *GuavaMapBuilder[K, V] {
+	b.bus = bus
+	return b
+}
+
+// WithLogger sets the logger for errors of background operations
+func (b *GuavaMapBuilder[K, V]) WithLogger(l zerolog.Logger) *GuavaMapBuilder[K, V] {
+	b.logger = l
+	return b
+}
+
 func (b *GuavaMapBuilder[K, V]) Build() *GuavaMap[K, V] {
+	res := b.build(b.maxCount)
+	if len(res.listeners) > 0 && b.listenerMode == guavaListenerExecutor {
+		res.listenerQueue = b.startListenerWorkers(res)
+	}
+	if b.l2 != nil && b.l2Mode == L2WriteBehind {
+		res.l2Queue = b.startL2Writer(res)
+	}
+	if b.bus != nil {
+		res.origin = newInvalidationOrigin()
+		b.subscribe(res.applyInvalidation, res.logger)
+	}
+	return res
+}
+
+func (b *GuavaMapBuilder[K, V]) subscribe(handler func(InvalidationMessage[K]), logger zerolog.Logger) {
+	if err := b.bus.Subscribe(b.ctx, handler); err != nil {
+		logger.Error().Err(err).Msg("failed to subscribe to invalidations")
+	}
+}
+
+func (b *GuavaMapBuilder[K, V]) startL2Writer(m *GuavaMap[K, V]) chan l2Op[K, V] {
+	queue := make(chan l2Op[K, V], defaultL2QueueSize)
+	go m.l2Writer(queue)
+	return queue
+}
+
+func (b *GuavaMapBuilder[K, V]) startListenerWorkers(m *GuavaMap[K, V]) chan RemovalNotification[K, V] {
+	workers := b.workers
+	if workers < 1 {
+		workers = 1
+	}
+	queue := make(chan RemovalNotification[K, V], b.queueSize)
+	for i := 0; i < workers; i++ {
+		go m.listenerWorker(queue)
+	}
+	return queue
+}
+
+func (b *GuavaMapBuilder[K, V]) build(maxCount int) *GuavaMap[K, V] {
 	res := &GuavaMap[K, V]{
 		stored:       make(map[K]*guavaHolder[V]),
 		loadFunc:     b.loadFunc,
-		maxCount:     b.maxCount,
-		unloadFunc:   b.unloadFunc,
+		maxCount:     maxCount,
+		listenerMode: b.listenerMode,
+		codec:        b.codec,
+		l2:           b.l2,
+		l2Mode:       b.l2Mode,
+		bus:          b.bus,
+		logger:       b.logger,
 		writeTimeout: b.writeTimeout,
 		readTimeout:  b.readTimeout,
 		ctx:          b.ctx,
 	}
+	if res.codec == nil {
+		res.codec = GobSnapshotCodec[K, V]{}
+	}
 	if b.lockLoad {
 		res.lockLoad = NewMapMutex[K]()
 	}
-	if b.maxCount > 0 {
-		res.storedSlice = make([]K, 0, b.maxCount)
+	if b.l2 != nil {
+		res.l2Versions = newL2Versions[K]()
+	}
+	if b.recordStats {
+		res.stats = &guavaStatsCounter{}
+	}
+	if b.unloadFunc != nil {
+		res.listeners = append(res.listeners, unloadListener(b.unloadFunc))
+	}
+	if b.listener != nil {
+		res.listeners = append(res.listeners, b.listener)
+	}
+	if maxCount > 0 {
+		res.storedSlice = make([]K, 0, maxCount)
 	}
 	return res
 }
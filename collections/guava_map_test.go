@@ -1,6 +1,7 @@
 package collections
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"github.com/stretchr/testify/assert"
@@ -320,3 +321,303 @@ func TestGuavaMap_Delete(t *testing.T) {
 		}).Build()
 	m.Delete(20)
 }
+
+func TestGuavaMap_Stats(t *testing.T) {
+	m := NewGuavaMap[int, int]().WithMaxCount(2).WithRecordStats().WithLoadFunc(func(key int) (int, error) {
+		if key < 0 {
+			return 0, fmt.Errorf("negative key")
+		}
+		time.Sleep(time.Millisecond)
+		return key * 10, nil
+	}).Build()
+	_, err := m.Get(1)
+	assert.Nil(t, err)
+	_, err = m.Get(1)
+	assert.Nil(t, err)
+	_, err = m.Get(-1)
+	assert.NotNil(t, err)
+	_, err = m.Get(2)
+	assert.Nil(t, err)
+	_, err = m.Get(3)
+	assert.Nil(t, err)
+	m.Delete(3)
+
+	s := m.Stats()
+	assert.Equal(t, uint64(1), s.HitCount)
+	assert.Equal(t, uint64(4), s.MissCount)
+	assert.Equal(t, uint64(3), s.LoadSuccessCount)
+	assert.Equal(t, uint64(1), s.LoadFailureCount)
+	assert.Equal(t, 0.2, s.HitRate())
+	assert.GreaterOrEqual(t, s.AverageLoadPenalty(), time.Duration(0))
+	assert.Greater(t, s.TotalLoadTime, time.Millisecond*3)
+	assert.Equal(t, uint64(1), s.SizeEvictionCount)
+	assert.Equal(t, uint64(1), s.ExplicitEvictionCount)
+	assert.Equal(t, uint64(2), s.EvictionCount())
+}
+
+func TestGuavaMap_StatsTimeout(t *testing.T) {
+	m := NewGuavaMap[int, int]().WithRecordStats().WithWriteTimeout(time.Millisecond * 20).Build()
+	m.Set(1, 10)
+	m.Set(2, 20)
+	time.Sleep(time.Millisecond * 60)
+	assert.Equal(t, 0, m.Size())
+	assert.Equal(t, uint64(2), m.Stats().WriteTimeoutEvictionCount)
+
+	disabled := NewGuavaMap[int, int]().Build()
+	disabled.Set(1, 10)
+	_, _ = disabled.Get(1)
+	assert.Equal(t, GuavaStats{}, disabled.Stats())
+}
+
+func TestGuavaMap_RemovalListener(t *testing.T) {
+	var mu sync.Mutex
+	var notifications []RemovalNotification[int, int]
+	m := NewGuavaMap[int, int]().WithMaxCount(2).WithSyncRemovalListener().
+		WithRemovalListener(func(n RemovalNotification[int, int]) {
+			mu.Lock()
+			notifications = append(notifications, n)
+			mu.Unlock()
+		}).Build()
+	m.Set(1, 10)
+	m.Set(1, 11)
+	m.LockForUpdate(1, func() int { return 12 })
+	m.Set(2, 20)
+	m.Set(3, 30)
+	m.Delete(2)
+	m.LockForUpdate(4, func() int { return 40 })
+	assert.Equal(t, 2, m.Size())
+	assert.Equal(t, []RemovalNotification[int, int]{
+		{Key: 1, Value: 10, Cause: RemovalReplaced},
+		{Key: 1, Value: 11, Cause: RemovalReplaced},
+		{Key: 1, Value: 12, Cause: RemovalSize},
+		{Key: 2, Value: 20, Cause: RemovalExplicit},
+	}, notifications)
+}
+
+func TestGuavaMap_RemovalExecutor(t *testing.T) {
+	var unloaded, removed, expired int32
+	m := NewGuavaMap[int, int]().WithWriteTimeout(time.Millisecond*20).WithRemovalExecutor(1, 10).
+		WithUnloadFunc(func(k int, v int) {
+			atomic.AddInt32(&unloaded, 1)
+		}).
+		WithRemovalListener(func(n RemovalNotification[int, int]) {
+			atomic.AddInt32(&removed, 1)
+			if n.Cause == RemovalWriteTimeout {
+				atomic.AddInt32(&expired, 1)
+			}
+		}).Build()
+	for i := 0; i < 50; i++ {
+		m.Set(i, i)
+		m.Set(i, i*10)
+	}
+	time.Sleep(time.Millisecond * 100)
+	assert.Equal(t, 0, m.Size())
+	assert.Equal(t, int32(50), atomic.LoadInt32(&unloaded))
+	assert.Equal(t, int32(100), atomic.LoadInt32(&removed))
+	assert.Equal(t, int32(50), atomic.LoadInt32(&expired))
+}
+
+func TestGuavaMap_Snapshot(t *testing.T) {
+	m := NewGuavaMap[string, int]().WithMaxCount(3).WithWriteTimeout(time.Second).Build()
+	m.Set("a", 1)
+	m.Set("b", 2)
+	m.Set("c", 3)
+	time.Sleep(time.Millisecond * 50)
+	buf := bytes.Buffer{}
+	assert.Nil(t, m.SaveSnapshot(&buf))
+
+	warm := NewGuavaMap[string, int]().WithMaxCount(2).WithWriteTimeout(time.Second).Build()
+	assert.Nil(t, warm.LoadSnapshot(&buf))
+	assert.Equal(t, map[string]int{"b": 2, "c": 3}, warm.GetStored())
+	h := warm.stored["b"]
+	assert.Less(t, time.Until(warm.expireAt(h)), time.Millisecond*960)
+	assert.Greater(t, time.Until(warm.expireAt(h)), time.Millisecond*500)
+
+	expired := NewGuavaMap[string, int]().WithWriteTimeout(time.Millisecond * 10).Build()
+	expired.Set("x", 1)
+	buf.Reset()
+	assert.Nil(t, expired.SaveSnapshot(&buf))
+	time.Sleep(time.Millisecond * 20)
+	restored := NewGuavaMap[string, int]().WithWriteTimeout(time.Second).Build()
+	assert.Nil(t, restored.LoadSnapshot(&buf))
+	assert.Equal(t, 0, restored.Size())
+
+	assert.NotNil(t, restored.LoadSnapshot(bytes.NewBufferString("garbage")))
+}
+
+func TestGuavaMap_Compute(t *testing.T) {
+	var removed []RemovalNotification[string, int]
+	m := NewGuavaMap[string, int]().WithSyncRemovalListener().WithRemovalListener(func(n RemovalNotification[string, int]) {
+		removed = append(removed, n)
+	}).Build()
+
+	v, ok := m.Compute("a", func(old int, exists bool) (int, ComputeAction) {
+		assert.False(t, exists)
+		return 1, ComputeSet
+	})
+	assert.True(t, ok)
+	assert.Equal(t, 1, v)
+
+	v, ok = m.ComputeIfPresent("a", func(key string, old int) (int, ComputeAction) {
+		return old + 1, ComputeSet
+	})
+	assert.True(t, ok)
+	assert.Equal(t, 2, v)
+
+	_, ok = m.ComputeIfPresent("b", func(key string, old int) (int, ComputeAction) {
+		t.Fatal("must not be called for absent key")
+		return 0, ComputeSet
+	})
+	assert.False(t, ok)
+
+	v, err := m.ComputeIfAbsent("a", func(key string) (int, error) {
+		t.Fatal("must not be called for present key")
+		return 0, nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, v)
+
+	_, err = m.ComputeIfAbsent("b", func(key string) (int, error) {
+		return 0, fmt.Errorf("load error")
+	})
+	assert.NotNil(t, err)
+	assert.Equal(t, 1, m.Size())
+
+	_, ok = m.Compute("a", func(old int, exists bool) (int, ComputeAction) {
+		return 0, ComputeDelete
+	})
+	assert.False(t, ok)
+	assert.Equal(t, 0, m.Size())
+	assert.Equal(t, []RemovalNotification[string, int]{
+		{Key: "a", Value: 1, Cause: RemovalReplaced},
+		{Key: "a", Value: 2, Cause: RemovalExplicit},
+	}, removed)
+}
+
+func TestGuavaMap_MergeConcurrent(t *testing.T) {
+	m := NewGuavaMap[int, int]().WithLockLoad(true).WithLoadFunc(func(key int) (int, error) {
+		time.Sleep(time.Millisecond * 10)
+		return 1000, nil
+	}).Build()
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		_, _ = m.Get(1)
+	}()
+	time.Sleep(time.Millisecond)
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			m.Merge(1, 1, func(old int, value int) int {
+				return old + value
+			})
+		}()
+	}
+	wg.Wait()
+	v, err := m.Get(1)
+	assert.Nil(t, err)
+	assert.Equal(t, 1100, v)
+}
+
+func TestGuavaMap_ComputeLocksKeyOnly(t *testing.T) {
+	m := NewGuavaMap[int, int]().Build()
+	m.Set(2, 20)
+	started, release := make(chan struct{}), make(chan struct{})
+	done := make(chan int)
+	calls := 0
+	go func() {
+		v, _ := m.ComputeIfAbsent(1, func(key int) (int, error) {
+			calls++
+			if calls == 1 {
+				close(started)
+				<-release
+			}
+			return 10, nil
+		})
+		done <- v
+	}()
+	<-started
+	// a slow loader blocks neither the other keys nor the writes of its own key
+	v, err := m.Get(2)
+	assert.Nil(t, err)
+	assert.Equal(t, 20, v)
+	m.Set(3, 30)
+	m.Set(1, 11)
+	close(release)
+	// the key was set while the loader ran, so ComputeIfAbsent sees it on the retry
+	assert.Equal(t, 11, <-done)
+	assert.Equal(t, 1, calls)
+	v, _ = m.Get(1)
+	assert.Equal(t, 11, v)
+}
+
+func TestGuavaMap_ComputeIfAbsentRetryAfterError(t *testing.T) {
+	m := NewGuavaMap[int, int]().Build()
+	started, release := make(chan struct{}), make(chan struct{})
+	type result struct {
+		v   int
+		err error
+	}
+	done := make(chan result)
+	go func() {
+		v, err := m.ComputeIfAbsent(1, func(key int) (int, error) {
+			close(started)
+			<-release
+			return 0, fmt.Errorf("boom")
+		})
+		done <- result{v, err}
+	}()
+	<-started
+	m.Set(1, 42)
+	close(release)
+	// the failed call lost the race to Set, the retry finds the stored value
+	r := <-done
+	assert.Nil(t, r.err)
+	assert.Equal(t, 42, r.v)
+}
+
+func TestGuavaMap_RangeAndInvalidate(t *testing.T) {
+	type tenantKey struct {
+		tenant string
+		id     int
+	}
+	unloaded := int32(0)
+	m := NewGuavaMap[tenantKey, int]().WithMaxCount(100).WithUnloadFunc(func(k tenantKey, v int) {
+		atomic.AddInt32(&unloaded, 1)
+	}).Build()
+	for i := 0; i < 10; i++ {
+		m.Set(tenantKey{tenant: "a", id: i}, i)
+		m.Set(tenantKey{tenant: "b", id: i}, i)
+	}
+	assert.Len(t, m.Keys(), 20)
+
+	sum := 0
+	m.Range(func(key tenantKey, value int) bool {
+		// callback is free to use the map
+		m.Has(key)
+		sum += value
+		return true
+	})
+	assert.Equal(t, 90, sum)
+
+	visited := 0
+	m.Range(func(key tenantKey, value int) bool {
+		visited++
+		return visited < 3
+	})
+	assert.Equal(t, 3, visited)
+
+	removed := m.InvalidateIf(func(key tenantKey, value int) bool {
+		return key.tenant == "a"
+	})
+	assert.Equal(t, 10, removed)
+	assert.Equal(t, 10, m.Size())
+
+	m.InvalidateAll([]tenantKey{{tenant: "b", id: 1}, {tenant: "b", id: 2}, {tenant: "c", id: 1}})
+	assert.Equal(t, 8, m.Size())
+	assert.Len(t, m.storedSlice, 8)
+	time.Sleep(time.Millisecond * 10)
+	assert.Equal(t, int32(12), atomic.LoadInt32(&unloaded))
+}
@@ -0,0 +1,110 @@
+package collections
+
+/*
+ __    _           ___
+|  |  |_|_____ ___|_  |
+|  |__| |     | .'|  _|
+|_____|_|_|_|_|__,|___|
+zed (14.03.2024)
+*/
+
+// RemovalNotification is passed to the GuavaRemovalListener when an entry leaves the map or its value is replaced
+type RemovalNotification[K comparable, V any] struct {
+	Key   K
+	Value V
+	Cause RemovalCause
+}
+
+type GuavaRemovalListener[K comparable, V any] func(RemovalNotification[K, V])
+
+type guavaListenerMode int
+
+const (
+	// listeners are called in a new goroutine for every notification
+	guavaListenerAsync guavaListenerMode = iota
+	// listeners are called by the goroutine which removed the entry, after the map lock is released
+	guavaListenerSync
+	// listeners are called by a fixed pool of workers
+	guavaListenerExecutor
+)
+
+// notify queues the notification, it is delivered by unlock. Must be called under m.mu write lock
+func (m *GuavaMap[K, V]) notify(key K, value V, cause RemovalCause) {
+	if len(m.listeners) == 0 {
+		return
+	}
+	m.pending = append(m.pending, RemovalNotification[K, V]{Key: key, Value: value, Cause: cause})
+}
+
+// unlock releases m.mu write lock, then delivers notifications, L2 changes and invalidations collected under the lock.
+// Invalidations are published after L2 is written, so replicas reload the new value. With L2WriteBehind the changes
+// are only queued here, their invalidations are published by the writer
+func (m *GuavaMap[K, V]) unlock() {
+	pending := m.pending
+	m.pending = nil
+	l2Pending := m.l2Pending
+	m.l2Pending = nil
+	deleted, updated := m.busDeleted, m.busUpdated
+	m.busDeleted, m.busUpdated = nil, nil
+	m.mu.Unlock()
+	if len(pending) > 0 {
+		m.dispatch(pending)
+	}
+	if len(l2Pending) > 0 {
+		m.writeL2(l2Pending)
+	}
+	if len(deleted) > 0 {
+		m.publish(InvalidationDelete, deleted)
+	}
+	if len(updated) > 0 {
+		m.publish(InvalidationUpdate, updated)
+	}
+}
+
+func (m *GuavaMap[K, V]) dispatch(pending []RemovalNotification[K, V]) {
+	switch m.listenerMode {
+	case guavaListenerSync:
+		for _, n := range pending {
+			m.callListeners(n)
+		}
+	case guavaListenerExecutor:
+		for _, n := range pending {
+			select {
+			case m.listenerQueue <- n:
+			case <-m.ctx.Done():
+				return
+			}
+		}
+	default:
+		for _, n := range pending {
+			go m.callListeners(n)
+		}
+	}
+}
+
+func (m *GuavaMap[K, V]) callListeners(n RemovalNotification[K, V]) {
+	for _, l := range m.listeners {
+		l(n)
+	}
+}
+
+func (m *GuavaMap[K, V]) listenerWorker(queue chan RemovalNotification[K, V]) {
+	for {
+		select {
+		case <-m.ctx.Done():
+			return
+		case n := <-queue:
+			m.callListeners(n)
+		}
+	}
+}
+
+// unloadListener adapts the legacy GuavaUnloadFunc, which is not interested in replaced values
+func unloadListener[K comparable, V any](fn GuavaUnloadFunc[K, V]) GuavaRemovalListener[K, V] {
+	return func(n RemovalNotification[K, V]) {
+		if n.Cause == RemovalReplaced {
+			return
+		}
+		fn(n.Key, n.Value)
+	}
+}
@@ -0,0 +1,250 @@
+package collections
+
+import (
+	"io"
+)
+
+/*
+ __    _           ___
+|  |  |_|_____ ___|_  |
+|  |__| |     | .'|  _|
+|_____|_|_|_|_|__,|___|
+zed (14.03.2024)
+*/
+
+const defaultGuavaConcurrency = 16
+
+// SegmentedGuavaMap splits keys between independent GuavaMap segments, each with its own lock,
+// like ShardMap does for plain maps. maxCount is divided between segments,
+// so size eviction is applied per segment and is approximate for the whole map
+type SegmentedGuavaMap[K comparable, V any] struct {
+	segments []*GuavaMap[K, V]
+	hash     func(K) uint64
+	codec    GuavaSnapshotCodec[K, V]
+}
+
+// WithConcurrencyLevel sets the number of segments created by BuildSegmented
+func (b *GuavaMapBuilder[K, V]) WithConcurrencyLevel(concurrency int) *GuavaMapBuilder[K, V] {
+	b.concurrency = concurrency
+	return b
+}
+
+func (b *GuavaMapBuilder[K, V]) BuildSegmented() *SegmentedGuavaMap[K, V] {
+	count := b.concurrency
+	if count < 1 {
+		count = defaultGuavaConcurrency
+	}
+	maxCount := 0
+	if b.maxCount > 0 {
+		maxCount = (b.maxCount + count - 1) / count
+	}
+	res := &SegmentedGuavaMap[K, V]{
+		segments: make([]*GuavaMap[K, V], count),
+		hash:     newKeyHasher[K](),
+	}
+	var queue chan RemovalNotification[K, V]
+	var l2Queue chan l2Op[K, V]
+	var versions *l2Versions[K]
+	for i := 0; i < count; i++ {
+		res.segments[i] = b.build(maxCount)
+		if b.l2 != nil {
+			// the shared writer applies the changes of all segments, so they are ordered by the same versions
+			if versions == nil {
+				versions = res.segments[i].l2Versions
+			}
+			res.segments[i].l2Versions = versions
+		}
+		if len(res.segments[i].listeners) > 0 && b.listenerMode == guavaListenerExecutor {
+			if queue == nil {
+				queue = b.startListenerWorkers(res.segments[i])
+			}
+			res.segments[i].listenerQueue = queue
+		}
+		if b.l2 != nil && b.l2Mode == L2WriteBehind {
+			// a single writer keeps the order of changes
+			if l2Queue == nil {
+				l2Queue = b.startL2Writer(res.segments[i])
+			}
+			res.segments[i].l2Queue = l2Queue
+		}
+	}
+	res.codec = res.segments[0].codec
+	if b.bus != nil {
+		// segments publish with the same origin, the map subscribes once and routes keys to segments
+		origin := newInvalidationOrigin()
+		for _, s := range res.segments {
+			s.origin = origin
+		}
+		b.subscribe(res.applyInvalidation, res.segments[0].logger)
+	}
+	return res
+}
+
+func (m *SegmentedGuavaMap[K, V]) applyInvalidation(msg InvalidationMessage[K]) {
+	if msg.Op == InvalidationClear {
+		for _, s := range m.segments {
+			s.applyInvalidation(msg)
+		}
+		return
+	}
+	bySegment := make(map[int][]K)
+	for _, k := range msg.Keys {
+		i := m.segmentIndex(k)
+		bySegment[i] = append(bySegment[i], k)
+	}
+	for i, keys := range bySegment {
+		m.segments[i].applyInvalidation(InvalidationMessage[K]{Origin: msg.Origin, Op: msg.Op, Keys: keys})
+	}
+}
+
+func (m *SegmentedGuavaMap[K, V]) segmentIndex(key K) int {
+	return int(m.hash(key) % uint64(len(m.segments)))
+}
+
+func (m *SegmentedGuavaMap[K, V]) segment(key K) *GuavaMap[K, V] {
+	return m.segments[m.segmentIndex(key)]
+}
+
+func (m *SegmentedGuavaMap[K, V]) Get(key K) (V, error) {
+	return m.segment(key).Get(key)
+}
+
+func (m *SegmentedGuavaMap[K, V]) Has(key K) bool {
+	return m.segment(key).Has(key)
+}
+
+func (m *SegmentedGuavaMap[K, V]) HasOrCreate(key K, value V) bool {
+	return m.segment(key).HasOrCreate(key, value)
+}
+
+func (m *SegmentedGuavaMap[K, V]) Set(key K, val V) {
+	m.segment(key).Set(key, val)
+}
+
+func (m *SegmentedGuavaMap[K, V]) Delete(key K) {
+	m.segment(key).Delete(key)
+}
+
+func (m *SegmentedGuavaMap[K, V]) LockForUpdate(key K, update func() V) V {
+	return m.segment(key).LockForUpdate(key, update)
+}
+
+func (m *SegmentedGuavaMap[K, V]) Compute(key K, fn func(old V, exists bool) (V, ComputeAction)) (V, bool) {
+	return m.segment(key).Compute(key, fn)
+}
+
+func (m *SegmentedGuavaMap[K, V]) ComputeIfAbsent(key K, fn func(K) (V, error)) (V, error) {
+	return m.segment(key).ComputeIfAbsent(key, fn)
+}
+
+func (m *SegmentedGuavaMap[K, V]) ComputeIfPresent(key K, fn func(key K, old V) (V, ComputeAction)) (V, bool) {
+	return m.segment(key).ComputeIfPresent(key, fn)
+}
+
+func (m *SegmentedGuavaMap[K, V]) Merge(key K, value V, fn func(old V, value V) V) V {
+	return m.segment(key).Merge(key, value, fn)
+}
+
+func (m *SegmentedGuavaMap[K, V]) Clear() {
+	for _, s := range m.segments {
+		s.mu.Lock()
+		s.clearLocked()
+		s.unlock()
+	}
+	if m.segments[0].bus != nil {
+		m.segments[0].publish(InvalidationClear, nil)
+	}
+}
+
+func (m *SegmentedGuavaMap[K, V]) Size() int {
+	count := 0
+	for _, s := range m.segments {
+		count += s.Size()
+	}
+	return count
+}
+
+func (m *SegmentedGuavaMap[K, V]) GetStored() map[K]V {
+	res := make(map[K]V)
+	for _, s := range m.segments {
+		s.mu.RLock()
+		for k, v := range s.stored {
+			res[k] = v.v
+		}
+		s.mu.RUnlock()
+	}
+	return res
+}
+
+func (m *SegmentedGuavaMap[K, V]) Range(fn func(key K, value V) bool) {
+	next := true
+	for _, s := range m.segments {
+		s.Range(func(key K, value V) bool {
+			next = fn(key, value)
+			return next
+		})
+		if !next {
+			return
+		}
+	}
+}
+
+func (m *SegmentedGuavaMap[K, V]) Keys() []K {
+	var keys []K
+	for _, s := range m.segments {
+		keys = append(keys, s.Keys()...)
+	}
+	return keys
+}
+
+func (m *SegmentedGuavaMap[K, V]) InvalidateAll(keys []K) {
+	bySegment := make(map[int][]K)
+	for _, k := range keys {
+		i := m.segmentIndex(k)
+		bySegment[i] = append(bySegment[i], k)
+	}
+	for i, segmentKeys := range bySegment {
+		m.segments[i].InvalidateAll(segmentKeys)
+	}
+}
+
+func (m *SegmentedGuavaMap[K, V]) InvalidateIf(fn func(key K, value V) bool) int {
+	count := 0
+	for _, s := range m.segments {
+		count += s.InvalidateIf(fn)
+	}
+	return count
+}
+
+// Stats returns the sum of all segment counters
+func (m *SegmentedGuavaMap[K, V]) Stats() GuavaStats {
+	var res GuavaStats
+	for _, s := range m.segments {
+		res = res.add(s.Stats())
+	}
+	return res
+}
+
+func (m *SegmentedGuavaMap[K, V]) SaveSnapshot(w io.Writer) error {
+	var entries []GuavaSnapshotEntry[K, V]
+	for _, s := range m.segments {
+		entries = append(entries, s.snapshotEntries()...)
+	}
+	return m.codec.Encode(w, entries)
+}
+
+func (m *SegmentedGuavaMap[K, V]) LoadSnapshot(r io.Reader) error {
+	entries, err := m.codec.Decode(r)
+	if err != nil {
+		return err
+	}
+	bySegment := make([][]GuavaSnapshotEntry[K, V], len(m.segments))
+	for _, e := range entries {
+		i := m.segmentIndex(e.Key)
+		bySegment[i] = append(bySegment[i], e)
+	}
+	for i, segmentEntries := range bySegment {
+		m.segments[i].restoreEntries(segmentEntries)
+	}
+	return nil
+}
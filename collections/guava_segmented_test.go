@@ -0,0 +1,147 @@
+package collections
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+/*
+ __    _           ___
+|  |  |_|_____ ___|_  |
+|  |__| |     | .'|  _|
+|_____|_|_|_|_|__,|___|
+zed (14.03.2024)
+*/
+
+func TestSegmentedGuavaMap_Get(t *testing.T) {
+	loadCount := int32(0)
+	unloadCount := int32(0)
+	m := NewGuavaMap[int, int]().WithConcurrencyLevel(4).WithMaxCount(100).WithRecordStats().
+		WithLoadFunc(func(key int) (int, error) {
+			atomic.AddInt32(&loadCount, 1)
+			return key * 10, nil
+		}).
+		WithRemovalExecutor(1, 100).
+		WithUnloadFunc(func(key int, value int) {
+			atomic.AddInt32(&unloadCount, 1)
+		}).BuildSegmented()
+	assert.Len(t, m.segments, 4)
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			v, err := m.Get(i % 50)
+			assert.Nil(t, err)
+			assert.Equal(t, (i%50)*10, v)
+		}(i)
+	}
+	wg.Wait()
+	assert.Equal(t, 50, m.Size())
+	assert.Len(t, m.Keys(), 50)
+	assert.Equal(t, uint64(100), m.Stats().RequestCount())
+
+	for i := 0; i < 1000; i++ {
+		m.Set(i, i)
+	}
+	assert.LessOrEqual(t, m.Size(), 100)
+	assert.Greater(t, m.Size(), 50)
+	for _, s := range m.segments {
+		assert.Greater(t, s.Size(), 0)
+	}
+
+	m.Clear()
+	assert.Equal(t, 0, m.Size())
+	time.Sleep(time.Millisecond * 50)
+	assert.Equal(t, m.Stats().EvictionCount(), uint64(atomic.LoadInt32(&unloadCount)))
+}
+
+func TestSegmentedGuavaMap_Snapshot(t *testing.T) {
+	m := NewGuavaMap[string, int]().WithConcurrencyLevel(8).WithWriteTimeout(time.Minute).BuildSegmented()
+	m.Set("a", 1)
+	m.Set("b", 2)
+	m.Merge("b", 3, func(old int, value int) int { return old + value })
+	buf := bytes.Buffer{}
+	assert.Nil(t, m.SaveSnapshot(&buf))
+
+	restored := NewGuavaMap[string, int]().WithConcurrencyLevel(3).WithWriteTimeout(time.Minute).BuildSegmented()
+	assert.Nil(t, restored.LoadSnapshot(&buf))
+	assert.Equal(t, map[string]int{"a": 1, "b": 5}, restored.GetStored())
+	assert.Equal(t, 1, restored.InvalidateIf(func(key string, value int) bool { return value > 1 }))
+	assert.Equal(t, 1, restored.Size())
+}
+
+func TestGuavaMap_ReadTimeoutWithoutTimerReset(t *testing.T) {
+	m := NewGuavaMap[int, int]().WithReadTimeout(time.Millisecond * 30).WithWriteTimeout(time.Millisecond * 80).
+		WithRecordStats().Build()
+	m.Set(1, 10)
+	m.Set(2, 20)
+	for i := 0; i < 6; i++ {
+		time.Sleep(time.Millisecond * 10)
+		assert.True(t, m.Has(1))
+	}
+	// key 2 was not read and expired by the read timeout, key 1 survives until the write timeout
+	assert.False(t, m.Has(2))
+	time.Sleep(time.Millisecond * 50)
+	assert.Equal(t, 0, m.Size())
+	s := m.Stats()
+	assert.Equal(t, uint64(1), s.ReadTimeoutEvictionCount)
+	assert.Equal(t, uint64(1), s.WriteTimeoutEvictionCount)
+}
+
+func benchmarkGuavaGetParallel(b *testing.B, get func(int) (int, error)) {
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			if _, err := get(i & 1023); err != nil {
+				b.Fatal(err)
+			}
+			i++
+		}
+	})
+}
+
+func BenchmarkGuavaMap_GetParallel(b *testing.B) {
+	m := NewGuavaMap[int, int]().WithReadTimeout(time.Minute).WithLoadFunc(func(key int) (int, error) {
+		return key * 10, nil
+	}).Build()
+	benchmarkGuavaGetParallel(b, m.Get)
+}
+
+func BenchmarkSegmentedGuavaMap_GetParallel(b *testing.B) {
+	m := NewGuavaMap[int, int]().WithReadTimeout(time.Minute).WithConcurrencyLevel(64).WithLoadFunc(func(key int) (int, error) {
+		return key * 10, nil
+	}).BuildSegmented()
+	benchmarkGuavaGetParallel(b, m.Get)
+}
+
+func benchmarkGuavaMixedParallel(b *testing.B, get func(int) (int, error), set func(int, int)) {
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			if i%10 == 0 {
+				set(i&1023, i)
+			} else if _, err := get(i & 1023); err != nil {
+				b.Fatal(err)
+			}
+			i++
+		}
+	})
+}
+
+func BenchmarkGuavaMap_MixedParallel(b *testing.B) {
+	m := NewGuavaMap[int, int]().WithMaxCount(512).Build()
+	benchmarkGuavaMixedParallel(b, m.Get, m.Set)
+}
+
+func BenchmarkSegmentedGuavaMap_MixedParallel(b *testing.B) {
+	m := NewGuavaMap[int, int]().WithMaxCount(512).WithConcurrencyLevel(64).BuildSegmented()
+	benchmarkGuavaMixedParallel(b, m.Get, m.Set)
+}
@@ -0,0 +1,98 @@
+package collections
+
+import (
+	"encoding/gob"
+	"io"
+	"time"
+)
+
+/*
+ __    _           ___
+|  |  |_|_____ ___|_  |
+|  |__| |     | .'|  _|
+|_____|_|_|_|_|__,|___|
+zed (14.03.2024)
+*/
+
+// GuavaSnapshotEntry is a single stored entry of the GuavaMap snapshot.
+// ExpireAt is the zero time for entries without expiration
+type GuavaSnapshotEntry[K comparable, V any] struct {
+	Key      K
+	Value    V
+	ExpireAt time.Time
+}
+
+// GuavaSnapshotCodec serializes GuavaMap snapshots
+type GuavaSnapshotCodec[K comparable, V any] interface {
+	Encode(w io.Writer, entries []GuavaSnapshotEntry[K, V]) error
+	Decode(r io.Reader) ([]GuavaSnapshotEntry[K, V], error)
+}
+
+// GobSnapshotCodec is the default snapshot codec, K and V must be gob encodable
+type GobSnapshotCodec[K comparable, V any] struct{}
+
+func (GobSnapshotCodec[K, V]) Encode(w io.Writer, entries []GuavaSnapshotEntry[K, V]) error {
+	return gob.NewEncoder(w).Encode(entries)
+}
+
+func (GobSnapshotCodec[K, V]) Decode(r io.Reader) ([]GuavaSnapshotEntry[K, V], error) {
+	var entries []GuavaSnapshotEntry[K, V]
+	if err := gob.NewDecoder(r).Decode(&entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// SaveSnapshot writes all stored entries with their expiration time to w
+func (m *GuavaMap[K, V]) SaveSnapshot(w io.Writer) error {
+	return m.codec.Encode(w, m.snapshotEntries())
+}
+
+// LoadSnapshot stores entries read from r, keeping the remaining time to live of every entry.
+// Expired entries and keys already present in the map are skipped
+func (m *GuavaMap[K, V]) LoadSnapshot(r io.Reader) error {
+	entries, err := m.codec.Decode(r)
+	if err != nil {
+		return err
+	}
+	m.restoreEntries(entries)
+	return nil
+}
+
+func (m *GuavaMap[K, V]) snapshotEntries() []GuavaSnapshotEntry[K, V] {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	entries := make([]GuavaSnapshotEntry[K, V], 0, len(m.stored))
+	if m.maxCount > 0 {
+		// keep eviction order
+		for _, k := range m.storedSlice {
+			h := m.stored[k]
+			entries = append(entries, GuavaSnapshotEntry[K, V]{Key: k, Value: h.v, ExpireAt: m.expireAt(h)})
+		}
+		return entries
+	}
+	for k, h := range m.stored {
+		entries = append(entries, GuavaSnapshotEntry[K, V]{Key: k, Value: h.v, ExpireAt: m.expireAt(h)})
+	}
+	return entries
+}
+
+func (m *GuavaMap[K, V]) restoreEntries(entries []GuavaSnapshotEntry[K, V]) {
+	m.mu.Lock()
+	defer m.unlock()
+	now := time.Now()
+	for _, e := range entries {
+		if _, ok := m.stored[e.Key]; ok {
+			continue
+		}
+		if e.ExpireAt.IsZero() || (m.readTimeout == 0 && m.writeTimeout == 0) {
+			m.insert(e.Key, e.Value)
+			continue
+		}
+		ttl := e.ExpireAt.Sub(now)
+		if ttl <= 0 {
+			continue
+		}
+		m.insertHolder(e.Key, m.createHolderWithTTL(e.Key, e.Value, ttl))
+	}
+}
@@ -0,0 +1,129 @@
+package collections
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+/*
+ __    _           ___
+|  |  |_|_____ ___|_  |
+|  |__| |     | .'|  _|
+|_____|_|_|_|_|__,|___|
+zed (14.03.2024)
+*/
+
+// GuavaStats is a point-in-time snapshot of GuavaMap counters.
+// Counters are collected only when the map is built with WithRecordStats.
+type GuavaStats struct {
+	HitCount                  uint64
+	MissCount                 uint64
+	LoadSuccessCount          uint64
+	LoadFailureCount          uint64
+	TotalLoadTime             time.Duration
+	SizeEvictionCount         uint64
+	ReadTimeoutEvictionCount  uint64
+	WriteTimeoutEvictionCount uint64
+	ExplicitEvictionCount     uint64
+}
+
+func (s GuavaStats) RequestCount() uint64 {
+	return s.HitCount + s.MissCount
+}
+
+// HitRate returns the ratio of Get calls served from the map, 1 when there were no requests
+func (s GuavaStats) HitRate() float64 {
+	requests := s.RequestCount()
+	if requests == 0 {
+		return 1
+	}
+	return float64(s.HitCount) / float64(requests)
+}
+
+func (s GuavaStats) MissRate() float64 {
+	requests := s.RequestCount()
+	if requests == 0 {
+		return 0
+	}
+	return float64(s.MissCount) / float64(requests)
+}
+
+func (s GuavaStats) LoadCount() uint64 {
+	return s.LoadSuccessCount + s.LoadFailureCount
+}
+
+// AverageLoadPenalty returns the average time spent in the load func
+func (s GuavaStats) AverageLoadPenalty() time.Duration {
+	loads := s.LoadCount()
+	if loads == 0 {
+		return 0
+	}
+	return s.TotalLoadTime / time.Duration(loads)
+}
+
+// EvictionCount returns the number of removed entries for all causes
+func (s GuavaStats) EvictionCount() uint64 {
+	return s.SizeEvictionCount + s.ReadTimeoutEvictionCount + s.WriteTimeoutEvictionCount + s.ExplicitEvictionCount
+}
+
+func (s GuavaStats) add(o GuavaStats) GuavaStats {
+	return GuavaStats{
+		HitCount:                  s.HitCount + o.HitCount,
+		MissCount:                 s.MissCount + o.MissCount,
+		LoadSuccessCount:          s.LoadSuccessCount + o.LoadSuccessCount,
+		LoadFailureCount:          s.LoadFailureCount + o.LoadFailureCount,
+		TotalLoadTime:             s.TotalLoadTime + o.TotalLoadTime,
+		SizeEvictionCount:         s.SizeEvictionCount + o.SizeEvictionCount,
+		ReadTimeoutEvictionCount:  s.ReadTimeoutEvictionCount + o.ReadTimeoutEvictionCount,
+		WriteTimeoutEvictionCount: s.WriteTimeoutEvictionCount + o.WriteTimeoutEvictionCount,
+		ExplicitEvictionCount:     s.ExplicitEvictionCount + o.ExplicitEvictionCount,
+	}
+}
+
+type guavaStatsCounter struct {
+	hits                  atomic.Uint64
+	misses                atomic.Uint64
+	loadSuccess           atomic.Uint64
+	loadFailure           atomic.Uint64
+	totalLoadTime         atomic.Int64
+	sizeEvictions         atomic.Uint64
+	readTimeoutEvictions  atomic.Uint64
+	writeTimeoutEvictions atomic.Uint64
+	explicitEvictions     atomic.Uint64
+}
+
+func (c *guavaStatsCounter) recordLoad(d time.Duration, err error) {
+	if err != nil {
+		c.loadFailure.Add(1)
+	} else {
+		c.loadSuccess.Add(1)
+	}
+	c.totalLoadTime.Add(int64(d))
+}
+
+func (c *guavaStatsCounter) recordRemoval(cause RemovalCause, count uint64) {
+	switch cause {
+	case RemovalSize:
+		c.sizeEvictions.Add(count)
+	case RemovalReadTimeout:
+		c.readTimeoutEvictions.Add(count)
+	case RemovalWriteTimeout:
+		c.writeTimeoutEvictions.Add(count)
+	case RemovalExplicit:
+		c.explicitEvictions.Add(count)
+	}
+}
+
+func (c *guavaStatsCounter) snapshot() GuavaStats {
+	return GuavaStats{
+		HitCount:                  c.hits.Load(),
+		MissCount:                 c.misses.Load(),
+		LoadSuccessCount:          c.loadSuccess.Load(),
+		LoadFailureCount:          c.loadFailure.Load(),
+		TotalLoadTime:             time.Duration(c.totalLoadTime.Load()),
+		SizeEvictionCount:         c.sizeEvictions.Load(),
+		ReadTimeoutEvictionCount:  c.readTimeoutEvictions.Load(),
+		WriteTimeoutEvictionCount: c.writeTimeoutEvictions.Load(),
+		ExplicitEvictionCount:     c.explicitEvictions.Load(),
+	}
+}
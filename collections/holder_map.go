@@ -4,9 +4,16 @@ import (
 	"context"
 	"github.com/go-errors/errors"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
+var (
+	ErrHolderNotFound  = errors.New("holder not found")
+	ErrHolderTimeout   = errors.New("holder timeout")
+	ErrHolderCancelled = errors.New("holder cancelled")
+)
+
 type holderMapHolder[V any] struct {
 	Object          V
 	Err             error
@@ -14,6 +21,8 @@ type holderMapHolder[V any] struct {
 	released        bool
 	releaseChanList []chan error
 	timeOutTimer    *time.Timer
+	createdAt       time.Time
+	pending         *atomic.Int64
 	mu              sync.Mutex
 }
 type HolderMap[K comparable, V any] struct {
@@ -22,6 +31,11 @@ type HolderMap[K comparable, V any] struct {
 	ctx                    context.Context
 	timeoutDuration        time.Duration
 	destroyElementDuration time.Duration
+	observer               *waitObserver[K]
+}
+
+func (c *HolderMap[K, V]) Stats() WaitStats {
+	return c.observer.stats()
 }
 
 func (c *HolderMap[K, V]) Get(trx K) (V, error) {
@@ -32,7 +46,7 @@ func (c *HolderMap[K, V]) Get(trx K) (V, error) {
 		return h.Object, h.Err
 	}
 	var defaultV V
-	return defaultV, errors.New("not found")
+	return defaultV, ErrHolderNotFound
 }
 
 func (c *HolderMap[K, V]) Count() int {
@@ -41,74 +55,108 @@ func (c *HolderMap[K, V]) Count() int {
 	return len(c.m)
 }
 
+// Wait blocks until the holder of trx is released, failed, timed out or the map context is done.
+// The first Wait for trx creates the holder with target
 func (c *HolderMap[K, V]) Wait(trx K, target V) error {
+	_, err := c.WaitCtx(context.Background(), trx, target)
+	return err
+}
+
+// WaitCtx works as Wait, but also stops waiting when ctx is done without affecting other waiters of trx.
+// Returns the held object and ErrHolderTimeout when the holder or ctx deadline is exceeded, ErrHolderCancelled when
+// ctx or the map context is cancelled, or the error passed to Error
+func (c *HolderMap[K, V]) WaitCtx(ctx context.Context, trx K, target V) (V, error) {
+	return c.getOrCreate(trx, target).wait(ctx)
+}
+
+func (c *HolderMap[K, V]) getOrCreate(trx K, target V) *holderMapHolder[V] {
 	c.mu.RLock()
 	h, ok := c.m[trx]
 	c.mu.RUnlock()
 	if ok { // Has holder
-		if h.released {
-			return h.Err
-		} else {
-			waitChan := make(chan error, 1)
-			h.mu.Lock()
-			h.releaseChanList = append(h.releaseChanList, waitChan)
-			h.mu.Unlock()
-			return <-waitChan
+		return h
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if h, ok = c.m[trx]; ok {
+		return h
+	}
+	h = &holderMapHolder[V]{
+		Object:       target,
+		inChan:       make(chan error, 1),
+		timeOutTimer: time.NewTimer(c.timeoutDuration),
+		createdAt:    time.Now(),
+		pending:      &c.observer.pending,
+	}
+	go func() {
+		defer h.timeOutTimer.Stop()
+		select {
+		case <-h.timeOutTimer.C:
+			h.release(ErrHolderTimeout)
+			c.observer.onTimeout(trx)
+		case <-c.ctx.Done():
+			h.release(ErrHolderCancelled)
+			return
+		case errInChan := <-h.inChan:
+			h.release(errInChan)
+			c.observer.onResolved(trx, h.createdAt)
 		}
-	} else {
-		c.mu.Lock()
-		h, ok = c.m[trx]
-		if ok {
+		go func() {
+			time.Sleep(c.destroyElementDuration)
+			c.mu.Lock()
+			delete(c.m, trx)
 			c.mu.Unlock()
-			if h.released {
-				return h.Err
-			} else {
-				waitChan := make(chan error, 1)
-				h.mu.Lock()
-				h.releaseChanList = append(h.releaseChanList, waitChan)
-				h.mu.Unlock()
-				return <-waitChan
-			}
-		} else {
-			waitChan := make(chan error, 1)
-			h = &holderMapHolder[V]{
-				Object:          target,
-				inChan:          make(chan error, 1),
-				releaseChanList: []chan error{waitChan},
-				timeOutTimer:    time.NewTimer(c.timeoutDuration),
+			c.observer.onExpired(trx)
+		}()
+	}()
+	c.m[trx] = h
+	c.observer.onCreated(trx)
+	return h
+}
+
+func (h *holderMapHolder[V]) wait(ctx context.Context) (V, error) {
+	h.mu.Lock()
+	if h.released {
+		defer h.mu.Unlock()
+		return h.Object, h.Err
+	}
+	waitChan := make(chan error, 1)
+	h.releaseChanList = append(h.releaseChanList, waitChan)
+	h.mu.Unlock()
+	h.pending.Add(1)
+	defer h.pending.Add(-1)
+	select {
+	case err := <-waitChan:
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		return h.Object, err
+	case <-ctx.Done():
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		if h.released {
+			return h.Object, h.Err
+		}
+		for i, ch := range h.releaseChanList {
+			if ch == waitChan {
+				h.releaseChanList = append(h.releaseChanList[:i], h.releaseChanList[i+1:]...)
+				break
 			}
-			go func() {
-				defer h.timeOutTimer.Stop()
-				select {
-				case <-h.timeOutTimer.C:
-					h.mu.Lock()
-					h.released = true
-					h.Err = errors.New("timeout")
-				case <-c.ctx.Done():
-					return
-				case errInChan := <-h.inChan:
-					h.mu.Lock()
-					h.released = true
-					h.Err = errInChan
-				}
-				for _, ch := range h.releaseChanList {
-					ch <- h.Err
-				}
-				h.mu.Unlock()
-				go func() {
-					time.Sleep(c.destroyElementDuration)
-					c.mu.Lock()
-					delete(c.m, trx)
-					c.mu.Unlock()
-				}()
-			}()
-			c.m[trx] = h
-			c.mu.Unlock()
-			return <-waitChan
 		}
+		return h.Object, ctxError(ctx, ErrHolderTimeout, ErrHolderCancelled)
 	}
 }
 
+func (h *holderMapHolder[V]) release(err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.released = true
+	h.Err = err
+	for _, ch := range h.releaseChanList {
+		ch <- err
+	}
+	h.releaseChanList = nil
+}
+
 func (c *HolderMap[K, V]) Update(trx K, target V) error {
 	c.mu.RLock()
 	h, ok := c.m[trx]
@@ -119,7 +167,7 @@ func (c *HolderMap[K, V]) Update(trx K, target V) error {
 		h.mu.Unlock()
 		return nil
 	} else {
-		return errors.New("not found")
+		return ErrHolderNotFound
 	}
 }
 
@@ -128,10 +176,13 @@ func (c *HolderMap[K, V]) Release(trx K) error {
 	h, ok := c.m[trx]
 	c.mu.RUnlock()
 	if ok {
-		h.inChan <- nil
+		select {
+		case h.inChan <- nil:
+		default: // already released, don't block
+		}
 		return nil
 	} else {
-		return errors.New("not found")
+		return ErrHolderNotFound
 	}
 }
 
@@ -140,10 +191,13 @@ func (c *HolderMap[K, V]) Error(trx K, err error) error {
 	h, ok := c.m[trx]
 	c.mu.RUnlock()
 	if ok {
-		h.inChan <- err
+		select {
+		case h.inChan <- err:
+		default: // already released, don't block
+		}
 		return nil
 	} else {
-		return errors.New("not found")
+		return ErrHolderNotFound
 	}
 }
 
@@ -151,6 +205,7 @@ type HolderMapBuilder[K comparable, V any] struct {
 	ctx                    context.Context
 	timeoutDuration        time.Duration
 	destroyElementDuration time.Duration
+	hooks                  WaitHooks[K]
 }
 
 func NewHolderMap[K comparable, V any]() *HolderMapBuilder[K, V] {
@@ -176,11 +231,17 @@ func (c *HolderMapBuilder[K, V]) WithTTL(d time.Duration) *HolderMapBuilder[K, V
 	return c
 }
 
+func (c *HolderMapBuilder[K, V]) WithHooks(hooks WaitHooks[K]) *HolderMapBuilder[K, V] {
+	c.hooks = hooks
+	return c
+}
+
 func (c *HolderMapBuilder[K, V]) Build() *HolderMap[K, V] {
 	return &HolderMap[K, V]{
 		m:                      make(map[K]*holderMapHolder[V]),
 		ctx:                    c.ctx,
 		timeoutDuration:        c.timeoutDuration,
 		destroyElementDuration: c.destroyElementDuration,
+		observer:               newWaitObserver(c.hooks),
 	}
 }
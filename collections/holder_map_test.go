@@ -1,7 +1,10 @@
 package collections
 
 import (
+	"context"
+	"errors"
 	"github.com/stretchr/testify/assert"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -42,3 +45,71 @@ func TestHolderMap_Release(t *testing.T) {
 	assert.Equal(t, hm.Count(), 0)
 
 }
+
+func TestHolderMap_Errors(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	hm := NewHolderMap[int, int]().WithContext(ctx).WithTimeout(time.Millisecond * 20).Build()
+	_, err := hm.Get(1)
+	assert.True(t, errors.Is(err, ErrHolderNotFound))
+	assert.True(t, errors.Is(hm.Release(1), ErrHolderNotFound))
+	assert.True(t, errors.Is(hm.Wait(1, 1), ErrHolderTimeout))
+
+	done := make(chan error, 2)
+	go func() { done <- hm.Wait(2, 2) }()
+	time.Sleep(time.Millisecond * 5)
+	go func() { done <- hm.Wait(2, 2) }()
+	time.Sleep(time.Millisecond * 5)
+	cancel()
+	for i := 0; i < 2; i++ {
+		select {
+		case err = <-done:
+			assert.True(t, errors.Is(err, ErrHolderCancelled))
+		case <-time.After(time.Millisecond * 100):
+			t.Fatal("waiter was not notified on cancel")
+		}
+	}
+	assert.True(t, errors.Is(hm.Wait(2, 2), ErrHolderCancelled))
+}
+
+func TestHolderMap_WaitCtx(t *testing.T) {
+	hm := NewHolderMap[int, int]().WithTimeout(time.Millisecond * 100).Build()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*10)
+	defer cancel()
+	_, err := hm.WaitCtx(ctx, 1, 1)
+	assert.True(t, errors.Is(err, ErrHolderTimeout))
+
+	done := make(chan error, 1)
+	go func() {
+		v, err := hm.WaitCtx(context.Background(), 1, 1)
+		assert.Equal(t, 2, v)
+		done <- err
+	}()
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	_, err = hm.WaitCtx(ctx, 1, 1)
+	assert.True(t, errors.Is(err, ErrHolderCancelled))
+	time.Sleep(time.Millisecond * 5)
+	assert.Nil(t, hm.Update(1, 2))
+	assert.Nil(t, hm.Release(1))
+	assert.Nil(t, <-done)
+}
+
+func TestHolderMap_Stats(t *testing.T) {
+	var expired atomic.Int32
+	hm := NewHolderMap[int, int]().WithTimeout(time.Millisecond * 20).WithTTL(time.Millisecond * 10).
+		WithHooks(WaitHooks[int]{OnExpired: func(key int) { expired.Add(1) }}).Build()
+	go func() {
+		time.Sleep(time.Millisecond * 5)
+		assert.Equal(t, int64(1), hm.Stats().Pending)
+		assert.Nil(t, hm.Release(1))
+	}()
+	assert.Nil(t, hm.Wait(1, 1))
+	assert.True(t, errors.Is(hm.Wait(2, 2), ErrHolderTimeout))
+	time.Sleep(time.Millisecond * 30)
+	stats := hm.Stats()
+	assert.Equal(t, uint64(2), stats.Created)
+	assert.Equal(t, uint64(1), stats.Resolved)
+	assert.Equal(t, uint64(1), stats.TimedOut)
+	assert.Equal(t, uint64(2), stats.Expired)
+	assert.Equal(t, int32(2), expired.Load())
+}
@@ -0,0 +1,250 @@
+package collections
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	axnet "github.com/axgrid/axutils/net"
+	"github.com/go-errors/errors"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"net"
+	"sync"
+	"time"
+)
+
+/*
+ __    _           ___
+|  |  |_|_____ ___|_  |
+|  |__| |     | .'|  _|
+|_____|_|_|_|_|__,|___|
+zed (14.03.2024)
+*/
+
+var ErrInvalidationQueueFull = errors.New("invalidation queue is full")
+
+const (
+	tcpInvalidationMaxFrame     = 16 << 20
+	tcpInvalidationDialTimeout  = time.Second * 3
+	tcpInvalidationWriteTimeout = time.Second * 3
+	tcpInvalidationQueueSize    = 1024
+	tcpInvalidationMinBackoff   = time.Millisecond * 100
+	tcpInvalidationMaxBackoff   = time.Second * 5
+)
+
+// TCPInvalidationTransport sends invalidation messages to the peers over TCP and receives messages from them.
+// Every message is a gob encoded InvalidationMessage prefixed with its 4 byte size (see net.AddSize32).
+// Every peer has a bounded queue drained by its own sender goroutine, which dials and reconnects in the background,
+// so Publish never waits on the network
+type TCPInvalidationTransport[K comparable] struct {
+	ctx      context.Context
+	listener net.Listener
+	logger   zerolog.Logger
+	mu       sync.RWMutex
+	peers    map[string]*tcpInvalidationPeer
+	handlers map[int]func(InvalidationMessage[K])
+	nextID   int
+	connsMu  sync.Mutex
+	conns    map[net.Conn]struct{}
+}
+
+type tcpInvalidationPeer struct {
+	addr  string
+	queue chan []byte
+}
+
+// NewTCPInvalidationTransport listens on listenAddr and publishes to peers until ctx is done
+func NewTCPInvalidationTransport[K comparable](ctx context.Context, listenAddr string, peers ...string) (*TCPInvalidationTransport[K], error) {
+	listener, err := net.Listen("tcp", listenAddr)
+	if err != nil {
+		return nil, err
+	}
+	t := &TCPInvalidationTransport[K]{
+		ctx:      ctx,
+		listener: listener,
+		logger:   log.Logger,
+		peers:    make(map[string]*tcpInvalidationPeer),
+		handlers: make(map[int]func(InvalidationMessage[K])),
+		conns:    make(map[net.Conn]struct{}),
+	}
+	for _, p := range peers {
+		t.AddPeer(p)
+	}
+	go t.accept()
+	context.AfterFunc(ctx, t.close)
+	return t, nil
+}
+
+func (t *TCPInvalidationTransport[K]) WithLogger(l zerolog.Logger) *TCPInvalidationTransport[K] {
+	t.logger = l
+	return t
+}
+
+// Addr returns the listening address, useful when listening on port 0
+func (t *TCPInvalidationTransport[K]) Addr() string {
+	return t.listener.Addr().String()
+}
+
+func (t *TCPInvalidationTransport[K]) AddPeer(addr string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := t.peers[addr]; ok {
+		return
+	}
+	p := &tcpInvalidationPeer{addr: addr, queue: make(chan []byte, tcpInvalidationQueueSize)}
+	t.peers[addr] = p
+	go t.send(p)
+}
+
+func (t *TCPInvalidationTransport[K]) Subscribe(ctx context.Context, handler func(InvalidationMessage[K])) error {
+	t.mu.Lock()
+	id := t.nextID
+	t.nextID++
+	t.handlers[id] = handler
+	t.mu.Unlock()
+	context.AfterFunc(ctx, func() {
+		t.mu.Lock()
+		delete(t.handlers, id)
+		t.mu.Unlock()
+	})
+	return nil
+}
+
+// Publish queues the message for all peers without blocking.
+// ErrInvalidationQueueFull is returned for the peers whose queue is full, the message is dropped for them
+func (t *TCPInvalidationTransport[K]) Publish(msg InvalidationMessage[K]) error {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(msg); err != nil {
+		return err
+	}
+	frame := axnet.AddSize32(buf.Bytes())
+	t.mu.RLock()
+	peers := make([]*tcpInvalidationPeer, 0, len(t.peers))
+	for _, p := range t.peers {
+		peers = append(peers, p)
+	}
+	t.mu.RUnlock()
+	var errs []error
+	for _, p := range peers {
+		select {
+		case p.queue <- frame:
+		default:
+			errs = append(errs, errors.Errorf("peer %s: %w", p.addr, ErrInvalidationQueueFull))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// send writes the queued frames to the peer, the connection is dialed again with a backoff until it succeeds
+func (t *TCPInvalidationTransport[K]) send(p *tcpInvalidationPeer) {
+	var conn net.Conn
+	defer func() {
+		if conn != nil {
+			_ = conn.Close()
+		}
+	}()
+	backoff := tcpInvalidationMinBackoff
+	for {
+		var frame []byte
+		select {
+		case <-t.ctx.Done():
+			return
+		case frame = <-p.queue:
+		}
+		for {
+			if conn == nil {
+				var err error
+				if conn, err = net.DialTimeout("tcp", p.addr, tcpInvalidationDialTimeout); err != nil {
+					conn = nil
+					t.logger.Warn().Err(err).Str("peer", p.addr).Msg("failed to dial invalidation peer")
+					select {
+					case <-t.ctx.Done():
+						return
+					case <-time.After(backoff):
+					}
+					backoff = min(backoff*2, tcpInvalidationMaxBackoff)
+					continue
+				}
+				backoff = tcpInvalidationMinBackoff
+			}
+			if err := t.write(conn, frame); err != nil {
+				t.logger.Warn().Err(err).Str("peer", p.addr).Msg("failed to write invalidation")
+				_ = conn.Close()
+				conn = nil
+				continue
+			}
+			break
+		}
+	}
+}
+
+func (t *TCPInvalidationTransport[K]) write(conn net.Conn, frame []byte) error {
+	if err := conn.SetWriteDeadline(time.Now().Add(tcpInvalidationWriteTimeout)); err != nil {
+		return err
+	}
+	_, err := conn.Write(frame)
+	return err
+}
+
+func (t *TCPInvalidationTransport[K]) accept() {
+	for {
+		conn, err := t.listener.Accept()
+		if err != nil {
+			if t.ctx.Err() == nil {
+				t.logger.Error().Err(err).Msg("failed to accept invalidation connection")
+			}
+			return
+		}
+		t.connsMu.Lock()
+		t.conns[conn] = struct{}{}
+		t.connsMu.Unlock()
+		go t.read(conn)
+	}
+}
+
+func (t *TCPInvalidationTransport[K]) read(conn net.Conn) {
+	defer func() {
+		t.connsMu.Lock()
+		delete(t.conns, conn)
+		t.connsMu.Unlock()
+		_ = conn.Close()
+	}()
+	for {
+		size, err := axnet.ReadNBytes(conn, 4)
+		if err != nil {
+			return
+		}
+		n := axnet.GetUInt32FromBytes(size)
+		if n > tcpInvalidationMaxFrame {
+			t.logger.Error().Uint32("size", n).Str("remote", conn.RemoteAddr().String()).Msg("invalidation frame is too large")
+			return
+		}
+		data, err := axnet.ReadNBytes(conn, int(n))
+		if err != nil {
+			return
+		}
+		var msg InvalidationMessage[K]
+		if err = gob.NewDecoder(bytes.NewReader(data)).Decode(&msg); err != nil {
+			t.logger.Error().Err(err).Str("remote", conn.RemoteAddr().String()).Msg("failed to decode invalidation")
+			return
+		}
+		t.mu.RLock()
+		handlers := make([]func(InvalidationMessage[K]), 0, len(t.handlers))
+		for _, h := range t.handlers {
+			handlers = append(handlers, h)
+		}
+		t.mu.RUnlock()
+		for _, h := range handlers {
+			h(msg)
+		}
+	}
+}
+
+func (t *TCPInvalidationTransport[K]) close() {
+	_ = t.listener.Close()
+	t.connsMu.Lock()
+	for conn := range t.conns {
+		_ = conn.Close()
+	}
+	t.connsMu.Unlock()
+}
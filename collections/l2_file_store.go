@@ -0,0 +1,197 @@
+package collections
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding"
+	"encoding/gob"
+	"encoding/hex"
+	"fmt"
+	"github.com/go-errors/errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+ __    _           ___
+|  |  |_|_____ ___|_  |
+|  |__| |     | .'|  _|
+|_____|_|_|_|_|__,|___|
+zed (14.03.2024)
+*/
+
+const fileL2StoreExt = ".l2"
+
+type fileL2Entry[V any] struct {
+	Value    V
+	ExpireAt time.Time
+}
+
+var ErrL2KeyNotEncodable = errors.New("l2 key type has pointers, set the key encoder")
+
+// FileL2Store is a reference L2Store keeping every value in a gob encoded file of the directory.
+// Several processes of the same host can share the directory, files are replaced atomically
+type FileL2Store[K comparable, V any] struct {
+	dir       string
+	encodeKey func(K) string
+}
+
+// NewFileL2Store creates the store for keys encoded by their encoding.TextMarshaler or by their go representation.
+// The go representation of pointers is their address, which differs between processes,
+// so ErrL2KeyNotEncodable is returned for key types with pointers, use NewFileL2StoreWithKeyEncoder for them
+func NewFileL2Store[K comparable, V any](dir string) (*FileL2Store[K, V], error) {
+	keyType := reflect.TypeFor[K]()
+	if keyType.Implements(reflect.TypeFor[encoding.TextMarshaler]()) {
+		return NewFileL2StoreWithKeyEncoder[K, V](dir, func(key K) string {
+			text, err := any(key).(encoding.TextMarshaler).MarshalText()
+			if err != nil {
+				return fmt.Sprintf("%#v", key)
+			}
+			return string(text)
+		})
+	}
+	if hasPointers(keyType) {
+		return nil, errors.Errorf("%w: %s", ErrL2KeyNotEncodable, keyType)
+	}
+	return NewFileL2StoreWithKeyEncoder[K, V](dir, func(key K) string {
+		return fmt.Sprintf("%#v", key)
+	})
+}
+
+// NewFileL2StoreWithKeyEncoder creates the store naming the files by encodeKey,
+// which must return the same string for equal keys in all processes sharing the directory
+func NewFileL2StoreWithKeyEncoder[K comparable, V any](dir string, encodeKey func(K) string) (*FileL2Store[K, V], error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &FileL2Store[K, V]{dir: dir, encodeKey: encodeKey}, nil
+}
+
+// hasPointers reports if the go representation of t depends on addresses
+func hasPointers(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Pointer, reflect.UnsafePointer, reflect.Interface, reflect.Chan, reflect.Map, reflect.Slice, reflect.Func:
+		return true
+	case reflect.Array:
+		return hasPointers(t.Elem())
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			if hasPointers(t.Field(i).Type) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// path returns the file of the key, named by a hash of the encoded key
+func (s *FileL2Store[K, V]) path(key K) string {
+	sum := sha256.Sum256([]byte(s.encodeKey(key)))
+	return filepath.Join(s.dir, hex.EncodeToString(sum[:])+fileL2StoreExt)
+}
+
+func (s *FileL2Store[K, V]) Get(key K) (V, bool, error) {
+	var empty V
+	entry, err := s.read(s.path(key))
+	if os.IsNotExist(err) {
+		return empty, false, nil
+	}
+	if err != nil {
+		return empty, false, err
+	}
+	// the expired file is left to RemoveExpired, another process may be replacing it with a fresh value
+	if !entry.ExpireAt.IsZero() && time.Now().After(entry.ExpireAt) {
+		return empty, false, nil
+	}
+	return entry.Value, true, nil
+}
+
+func (s *FileL2Store[K, V]) Set(key K, value V, ttl time.Duration) error {
+	entry := fileL2Entry[V]{Value: value}
+	if ttl > 0 {
+		entry.ExpireAt = time.Now().Add(ttl)
+	}
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(entry); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(s.dir, "tmp-*")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(buf.Bytes())
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path(key))
+}
+
+func (s *FileL2Store[K, V]) Delete(key K) error {
+	err := os.Remove(s.path(key))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// RemoveExpired deletes files of expired values
+func (s *FileL2Store[K, V]) RemoveExpired() error {
+	files, err := os.ReadDir(s.dir)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	for _, f := range files {
+		if f.IsDir() || !strings.HasSuffix(f.Name(), fileL2StoreExt) {
+			continue
+		}
+		path := filepath.Join(s.dir, f.Name())
+		entry, err := s.read(path)
+		if err != nil {
+			continue
+		}
+		if !entry.ExpireAt.IsZero() && now.After(entry.ExpireAt) {
+			if err = s.removeExpired(path, now); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// removeExpired moves the file to a tombstone and checks it again, so a fresh value written by another process
+// after the first check is put back instead of being deleted
+func (s *FileL2Store[K, V]) removeExpired(path string, now time.Time) error {
+	tombstone := path + ".del-" + strconv.FormatInt(time.Now().UnixNano(), 36)
+	if err := os.Rename(path, tombstone); err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer os.Remove(tombstone)
+	entry, err := s.read(tombstone)
+	if err == nil && (entry.ExpireAt.IsZero() || !now.After(entry.ExpireAt)) {
+		// Link fails if the key was written again meanwhile, the newer file is kept
+		_ = os.Link(tombstone, path)
+	}
+	return nil
+}
+
+func (s *FileL2Store[K, V]) read(path string) (fileL2Entry[V], error) {
+	var entry fileL2Entry[V]
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return entry, err
+	}
+	err = gob.NewDecoder(bytes.NewReader(data)).Decode(&entry)
+	return entry, err
+}
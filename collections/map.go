@@ -1,6 +1,10 @@
 package collections
 
-import "sync"
+import (
+	"context"
+	"iter"
+	"sync"
+)
 
 /*
  __    _           ___
@@ -18,11 +22,26 @@ type Map[K comparable, V any] interface {
 	SetIfNotExists(key K, value V) bool
 	SetIfNotExistsWithFunc(key K, fn func() V) (V, bool)
 	Size() int
+	// Range calls fn for a snapshot of the entries until fn returns false, fn may call the map
+	Range(fn func(key K, value V) bool)
+	Keys() []K
+	Values() []V
+	Clear()
+	// Compute atomically calls fn with the current value of the key and applies the returned action.
+	// It returns the value associated with the key after the call and whether the key is present.
+	// fn is called under the map lock and must not call the map
+	Compute(key K, fn func(old V, exists bool) (V, ComputeAction)) (V, bool)
+	GetAndDelete(key K) (V, bool)
+	// CompareAndSwap sets new if the current value of the key is equal to old by eq
+	CompareAndSwap(key K, old, new V, eq func(a, b V) bool) bool
+	// All returns an iterator over a snapshot of the entries
+	All() iter.Seq2[K, V]
 }
 
 type SimpleMap[K comparable, V any] struct {
-	m  map[K]V
-	mu sync.RWMutex
+	m     map[K]V
+	mu    sync.RWMutex
+	watch watchHub[K, V]
 }
 
 func NewSimpleMap[K comparable, V any]() *SimpleMap[K, V] {
@@ -41,12 +60,19 @@ func (m *SimpleMap[K, V]) Get(key K) (V, bool) {
 func (m *SimpleMap[K, V]) Set(key K, value V) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
+	if m.watch.watching() {
+		old, existed := m.m[key]
+		defer m.watch.notifySet(key, old, existed, value)
+	}
 	m.m[key] = value
 }
 
 func (m *SimpleMap[K, V]) Delete(key K) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
+	if old, ok := m.m[key]; ok && m.watch.watching() {
+		defer m.watch.notifyDelete(key, old)
+	}
 	delete(m.m, key)
 }
 
@@ -64,6 +90,9 @@ func (m *SimpleMap[K, V]) SetIfNotExists(key K, value V) bool {
 		return false
 	}
 	m.m[key] = value
+	if m.watch.watching() {
+		m.watch.notifySet(key, value, false, value)
+	}
 	return true
 }
 
@@ -75,6 +104,9 @@ func (m *SimpleMap[K, V]) SetIfNotExistsWithFunc(key K, fn func() V) (V, bool) {
 	}
 	v := fn()
 	m.m[key] = v
+	if m.watch.watching() {
+		m.watch.notifySet(key, v, false, v)
+	}
 	return v, true
 }
 
@@ -83,3 +115,117 @@ func (m *SimpleMap[K, V]) Size() int {
 	defer m.mu.RUnlock()
 	return len(m.m)
 }
+
+func (m *SimpleMap[K, V]) Range(fn func(key K, value V) bool) {
+	m.mu.RLock()
+	keys := make([]K, 0, len(m.m))
+	values := make([]V, 0, len(m.m))
+	for k, v := range m.m {
+		keys = append(keys, k)
+		values = append(values, v)
+	}
+	m.mu.RUnlock()
+	for i := range keys {
+		if !fn(keys[i], values[i]) {
+			return
+		}
+	}
+}
+
+func (m *SimpleMap[K, V]) Keys() []K {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	keys := make([]K, 0, len(m.m))
+	for k := range m.m {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+func (m *SimpleMap[K, V]) Values() []V {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	values := make([]V, 0, len(m.m))
+	for _, v := range m.m {
+		values = append(values, v)
+	}
+	return values
+}
+
+func (m *SimpleMap[K, V]) Clear() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.watch.watching() {
+		for k, v := range m.m {
+			m.watch.notifyDelete(k, v)
+		}
+	}
+	m.m = make(map[K]V)
+}
+
+func (m *SimpleMap[K, V]) Compute(key K, fn func(old V, exists bool) (V, ComputeAction)) (V, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return computeWatched(&m.watch, m.m, key, fn)
+}
+
+func (m *SimpleMap[K, V]) GetAndDelete(key K) (V, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	v, ok := m.m[key]
+	delete(m.m, key)
+	if ok && m.watch.watching() {
+		m.watch.notifyDelete(key, v)
+	}
+	return v, ok
+}
+
+func (m *SimpleMap[K, V]) CompareAndSwap(key K, old, new V, eq func(a, b V) bool) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	cur := m.m[key]
+	if !compareAndSwapEntry(m.m, key, old, new, eq) {
+		return false
+	}
+	if m.watch.watching() {
+		m.watch.notifySet(key, cur, true, new)
+	}
+	return true
+}
+
+func (m *SimpleMap[K, V]) All() iter.Seq2[K, V] {
+	return m.Range
+}
+
+func (m *SimpleMap[K, V]) Watch(ctx context.Context, opts ...WatchOption) <-chan Event[K, V] {
+	return m.watch.watch(ctx, opts)
+}
+
+func (m *SimpleMap[K, V]) WatchKey(ctx context.Context, key K, opts ...WatchOption) <-chan Event[K, V] {
+	return m.watch.watchKey(ctx, key, opts)
+}
+
+func computeEntry[K comparable, V any](m map[K]V, key K, fn func(old V, exists bool) (V, ComputeAction)) (V, bool) {
+	old, exists := m[key]
+	v, action := fn(old, exists)
+	switch action {
+	case ComputeSet:
+		m[key] = v
+		return v, true
+	case ComputeDelete:
+		delete(m, key)
+		var empty V
+		return empty, false
+	default:
+		return old, exists
+	}
+}
+
+func compareAndSwapEntry[K comparable, V any](m map[K]V, key K, old, new V, eq func(a, b V) bool) bool {
+	cur, ok := m[key]
+	if !ok || !eq(cur, old) {
+		return false
+	}
+	m[key] = new
+	return true
+}
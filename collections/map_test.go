@@ -0,0 +1,351 @@
+package collections
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"hash/maphash"
+	"math"
+	"math/rand/v2"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+/*
+ __    _           ___
+|  |  |_|_____ ___|_  |
+|  |__| |     | .'|  _|
+|_____|_|_|_|_|__,|___|
+zed (11.04.2024)
+*/
+
+func TestMap_Interface(t *testing.T) {
+	maps := map[string]Map[int, int]{
+		"simple": NewSimpleMap[int, int](),
+		"shard":  NewShardMap[int, int]().WithShardsFunc(func(k int) int { return k % 6 }).Build(),
+		"cow":    NewCopyOnWriteMap[int, int](),
+	}
+	eq := func(a, b int) bool { return a == b }
+	for name, m := range maps {
+		t.Run(name, func(t *testing.T) {
+			for i := 0; i < 10; i++ {
+				m.Set(i, i*10)
+			}
+			keys := m.Keys()
+			sort.Ints(keys)
+			assert.Equal(t, []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}, keys)
+			values := m.Values()
+			sort.Ints(values)
+			assert.Equal(t, []int{0, 10, 20, 30, 40, 50, 60, 70, 80, 90}, values)
+
+			sum := 0
+			m.Range(func(key int, value int) bool {
+				sum += value
+				// fn may change the map
+				m.Delete(key)
+				return true
+			})
+			assert.Equal(t, 450, sum)
+			assert.Equal(t, 0, m.Size())
+
+			v, ok := m.Compute(1, func(old int, exists bool) (int, ComputeAction) {
+				assert.False(t, exists)
+				return 5, ComputeSet
+			})
+			assert.True(t, ok)
+			assert.Equal(t, 5, v)
+			v, ok = m.Compute(1, func(old int, exists bool) (int, ComputeAction) { return old + 1, ComputeSet })
+			assert.Equal(t, 6, v)
+			v, ok = m.Compute(1, func(old int, exists bool) (int, ComputeAction) { return old, ComputeKeep })
+			assert.Equal(t, 6, v)
+			_, ok = m.Compute(1, func(old int, exists bool) (int, ComputeAction) { return 0, ComputeDelete })
+			assert.False(t, ok)
+			assert.False(t, m.Has(1))
+
+			m.Set(2, 20)
+			assert.False(t, m.CompareAndSwap(2, 10, 30, eq))
+			assert.True(t, m.CompareAndSwap(2, 20, 30, eq))
+			assert.False(t, m.CompareAndSwap(3, 0, 30, eq))
+			v, ok = m.GetAndDelete(2)
+			assert.True(t, ok)
+			assert.Equal(t, 30, v)
+			_, ok = m.GetAndDelete(2)
+			assert.False(t, ok)
+
+			m.Set(4, 40)
+			m.Set(5, 50)
+			count := 0
+			for k, v := range m.All() {
+				assert.Equal(t, k*10, v)
+				count++
+				break
+			}
+			assert.Equal(t, 1, count)
+			m.Clear()
+			assert.Equal(t, 0, m.Size())
+		})
+	}
+}
+
+func TestShardMap_DefaultHash(t *testing.T) {
+	type key struct {
+		ID   int
+		Name string
+	}
+	m := NewShardMap[key, int]().WithShardsCount(8).Build()
+	for i := 0; i < 800; i++ {
+		m.Set(key{ID: i, Name: "k"}, i)
+	}
+	assert.Equal(t, 800, m.Size())
+	v, ok := m.Get(key{ID: 10, Name: "k"})
+	assert.True(t, ok)
+	assert.Equal(t, 10, v)
+	for i := range m.shards {
+		assert.Greater(t, len(m.shards[i]), 50)
+	}
+
+	ints := NewShardMap[int, int]().Build()
+	ints.Set(1, 1)
+	assert.True(t, ints.Has(1))
+
+	// out of range results of a custom func are normalized
+	custom := NewShardMap[int, int]().WithShardsCount(4).WithShardsFunc(func(k int) int { return k }).Build()
+	for i := -10; i < 10; i++ {
+		custom.Set(i, i)
+	}
+	assert.Equal(t, 20, custom.Size())
+	v, _ = custom.Get(-7)
+	assert.Equal(t, -7, v)
+}
+
+func TestShardMap_HashValue(t *testing.T) {
+	type key struct {
+		ID  int
+		F   float64
+		Any any
+		P   *int
+	}
+	hash := func(k key) uint64 {
+		var h maphash.Hash
+		h.SetSeed(shardSeed)
+		hashValue(&h, reflect.ValueOf(k))
+		return h.Sum64()
+	}
+	p := new(int)
+	// equal keys are hashed the same way, -0 equals 0
+	assert.Equal(t, hash(key{ID: 1, Any: "a", P: p}), hash(key{ID: 1, F: math.Copysign(0, -1), Any: "a", P: p}))
+	assert.NotEqual(t, hash(key{ID: 1}), hash(key{ID: 2}))
+	assert.NotEqual(t, hash(key{Any: 1}), hash(key{Any: int64(1)}))
+	assert.NotEqual(t, hash(key{P: p}), hash(key{P: new(int)}))
+}
+
+func TestCopyOnWriteMap_SetMany(t *testing.T) {
+	m := NewCopyOnWriteMap[string, int]()
+	m.SetMany(map[string]int{"a": 1, "b": 2})
+	m.Set("c", 3)
+	assert.Equal(t, 3, m.Size())
+	v, ok := m.Get("b")
+	assert.True(t, ok)
+	assert.Equal(t, 2, v)
+}
+
+// BenchmarkMap_ReadWrite compares the maps for different write ratios, run with -cpu 1,4,8 for the core counts
+func BenchmarkMap_ReadWrite(b *testing.B) {
+	const keys = 1024
+	for _, writePercent := range []int{0, 1, 10, 50} {
+		maps := []struct {
+			name string
+			m    Map[int, int]
+		}{
+			{"simple", NewSimpleMap[int, int]()},
+			{"shard", NewShardMap[int, int]().WithShardsCount(32).Build()},
+			{"cow", NewCopyOnWriteMap[int, int]()},
+		}
+		for _, mm := range maps {
+			m := mm.m
+			for i := 0; i < keys; i++ {
+				m.Set(i, i)
+			}
+			b.Run(fmt.Sprintf("%s/writes-%d%%", mm.name, writePercent), func(b *testing.B) {
+				b.RunParallel(func(pb *testing.PB) {
+					r := rand.New(rand.NewPCG(rand.Uint64(), rand.Uint64()))
+					for pb.Next() {
+						k := r.IntN(keys)
+						if r.IntN(100) < writePercent {
+							m.Set(k, k)
+						} else {
+							m.Get(k)
+						}
+					}
+				})
+			})
+		}
+	}
+}
+
+func TestShardMap_SetWithTTL(t *testing.T) {
+	clock := &testClock{now: time.Now()}
+	var mu sync.Mutex
+	evicted := map[string]int{}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	m := NewShardMap[string, int]().
+		WithContext(ctx).
+		WithClock(clock).
+		WithJanitorInterval(10 * time.Millisecond).
+		WithEvictionFunc(func(key string, value int) {
+			mu.Lock()
+			defer mu.Unlock()
+			evicted[key] = value
+		}).
+		Build()
+
+	m.Set("plain", 1)
+	m.SetWithTTL("session", 2, time.Minute)
+	m.SetWithTTL("renewed", 3, time.Minute)
+	m.SetWithTTL("persisted", 4, time.Minute)
+	m.Set("persisted", 5)
+
+	v, ok := m.Get("session")
+	assert.True(t, ok)
+	assert.Equal(t, 2, v)
+
+	clock.Add(30 * time.Second)
+	m.SetWithTTL("renewed", 6, time.Minute)
+	clock.Add(31 * time.Second)
+
+	_, ok = m.Get("session")
+	assert.False(t, ok)
+	assert.False(t, m.Has("session"))
+	assert.True(t, m.Has("renewed"))
+	assert.True(t, m.Has("persisted"))
+	keys := m.Keys()
+	sort.Strings(keys)
+	assert.Equal(t, []string{"persisted", "plain", "renewed"}, keys)
+
+	assert.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(evicted) == 1
+	}, time.Second, 5*time.Millisecond)
+	assert.Equal(t, map[string]int{"session": 2}, evicted)
+	assert.Equal(t, 3, m.Size())
+
+	assert.True(t, m.SetIfNotExists("session", 7))
+	clock.Add(time.Hour)
+	assert.Eventually(t, func() bool { return m.Size() == 3 }, time.Second, 5*time.Millisecond)
+	v, ok = m.Get("session")
+	assert.True(t, ok)
+	assert.Equal(t, 7, v)
+}
+
+func TestShardMap_TTLLazyExpiry(t *testing.T) {
+	clock := &testClock{now: time.Now()}
+	evicted := 0
+	m := NewShardMap[int, int]().
+		WithClock(clock).
+		WithJanitorInterval(time.Hour).
+		WithEvictionFunc(func(key int, value int) { evicted++ }).
+		Build()
+	eq := func(a, b int) bool { return a == b }
+
+	m.SetWithTTL(1, 10, time.Second)
+	v, ok := m.Compute(1, func(old int, exists bool) (int, ComputeAction) { return old + 1, ComputeSet })
+	assert.True(t, ok)
+	assert.Equal(t, 11, v)
+
+	clock.Add(2 * time.Second)
+	assert.False(t, m.CompareAndSwap(1, 11, 12, eq))
+	assert.Equal(t, 1, evicted)
+	assert.Equal(t, 0, m.Size())
+
+	m.SetWithTTL(2, 20, time.Second)
+	clock.Add(2 * time.Second)
+	_, ok = m.GetAndDelete(2)
+	assert.False(t, ok)
+	assert.Equal(t, 2, evicted)
+}
+
+func TestShardMap_TTLRenewalKeepsOneItem(t *testing.T) {
+	clock := &testClock{now: time.Now()}
+	m := NewShardMap[int, int]().WithShardsCount(1).WithClock(clock).WithJanitorInterval(time.Hour).Build()
+	for i := 0; i < 100; i++ {
+		m.SetWithTTL(1, i, time.Second)
+		m.SetWithTTL(2, i, time.Minute)
+	}
+	m.SetWithTTL(3, 0, time.Second)
+	m.Delete(3)
+	assert.Len(t, m.expiries[0].queue, 2)
+
+	clock.Add(2 * time.Second)
+	m.sweep()
+	assert.Equal(t, []int{2}, m.Keys())
+	assert.Len(t, m.expiries[0].queue, 1)
+	m.Set(2, 0)
+	assert.Len(t, m.expiries[0].queue, 0)
+}
+
+func TestShardMap_Atomically(t *testing.T) {
+	m := NewShardMap[string, int]().WithShardsCount(4).Build()
+	m.Set("alice", 100)
+	m.Set("bob", 50)
+
+	transfer := func(from, to string, amount int) error {
+		return m.Atomically([]string{from, to}, func(tx Tx[string, int]) error {
+			balance, _ := tx.Get(from)
+			if balance < amount {
+				return errors.New("insufficient funds")
+			}
+			target, _ := tx.Get(to)
+			_ = tx.Set(from, balance-amount)
+			return tx.Set(to, target+amount)
+		})
+	}
+
+	assert.NoError(t, transfer("alice", "bob", 30))
+	assert.Error(t, transfer("bob", "alice", 1000))
+	v, _ := m.Get("alice")
+	assert.Equal(t, 70, v)
+	v, _ = m.Get("bob")
+	assert.Equal(t, 80, v)
+
+	err := m.Atomically([]string{"alice"}, func(tx Tx[string, int]) error {
+		assert.NoError(t, tx.Delete("alice"))
+		_, ok := tx.Get("alice")
+		assert.False(t, ok)
+		return tx.Set("carol", 1)
+	})
+	assert.ErrorIs(t, err, ErrTxKeyNotDeclared)
+	assert.True(t, m.Has("alice"))
+	assert.False(t, m.Has("carol"))
+
+	players := []string{"a", "b", "c", "d", "e", "f", "g", "h"}
+	for _, p := range players {
+		m.Set(p, 1000)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r := rand.New(rand.NewPCG(uint64(i), 0))
+			for j := 0; j < 500; j++ {
+				from, to := players[r.IntN(len(players))], players[r.IntN(len(players))]
+				if from != to {
+					_ = transfer(from, to, r.IntN(50))
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	total := 0
+	for _, p := range players {
+		v, _ := m.Get(p)
+		total += v
+	}
+	assert.Equal(t, 8000, total)
+}
@@ -1,13 +1,27 @@
 package collections
 
 import (
+	"container/list"
 	"context"
+	"fmt"
 	"github.com/go-errors/errors"
+	"runtime/debug"
 	"sync"
 	"time"
 )
 
-var ErrTimeout = errors.New("timeout")
+var (
+	ErrTimeout   = errors.New("timeout")
+	ErrCancelled = errors.New("cancelled")
+)
+
+// ctxError maps the reason ctx is done to timeoutErr for an exceeded deadline or cancelledErr otherwise
+func ctxError(ctx context.Context, timeoutErr, cancelledErr error) error {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return timeoutErr
+	}
+	return cancelledErr
+}
 
 type RequestMapInitializer[K comparable, V any] struct {
 	Key    K
@@ -15,62 +29,90 @@ type RequestMapInitializer[K comparable, V any] struct {
 	Err    error
 }
 
+// RequestPanicError is returned to all waiters of a call when f panics
+type RequestPanicError struct {
+	Value any
+	Stack []byte
+}
+
+func (e *RequestPanicError) Error() string {
+	return fmt.Sprintf("request panic: %v\n%s", e.Value, e.Stack)
+}
+
 type resultHolder[K comparable, V any] struct {
 	key        K
 	result     V
 	err        error
 	resultTime time.Time
+	expireAt   time.Time
+	// elem is the position of the key in the lru list, if the map is bounded
+	elem *list.Element
+	// queued is the position of the key in the expiry queue of results or errors
+	queued *list.Element
+}
+
+// Clock returns the current time, it is used to check the expiry of the results
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// requestCall is an in-flight call of f, shared by all callers of the key until it completes.
+// The context of the call is cancelled when all its waiters have gone away
+type requestCall[K comparable, V any] struct {
+	ctx       context.Context
+	cancel    context.CancelFunc
+	waiters   []chan resultHolder[K, V]
+	forgotten bool
+	refresh   bool
 }
 
 type RequestMap[K comparable, V any] struct {
-	waiters     map[K][]chan resultHolder[K, V]
-	response    map[K]resultHolder[K, V]
-	mu          sync.RWMutex
-	deleteAfter time.Duration
-	resultSlice []resultHolder[K, V]
-	ctx         context.Context
+	calls        map[K]*requestCall[K, V]
+	response     map[K]resultHolder[K, V]
+	mu           sync.RWMutex
+	deleteAfter  time.Duration
+	errorTTL     time.Duration
+	retry        *RetryPolicy
+	resultQueue  *list.List
+	errorQueue   *list.List
+	ctx          context.Context
+	singleFlight bool
+	clock        Clock
+	maxEntries   int
+	lru          *list.List
 }
 
+// NewRequestMap returns RequestMap which keeps results and errors for ttl, see NewRequestMapBuilder for other options
 func NewRequestMap[K comparable, V any](ctx context.Context, ttl time.Duration, init ...*RequestMapInitializer[K, V]) *RequestMap[K, V] {
-	res := &RequestMap[K, V]{
-		waiters:     make(map[K][]chan resultHolder[K, V]),
-		response:    make(map[K]resultHolder[K, V]),
-		mu:          sync.RWMutex{},
-		deleteAfter: ttl,
-		ctx:         ctx,
-	}
-	if len(init) > 0 {
-		for _, i := range init {
-			res.response[i.Key] = resultHolder[K, V]{key: i.Key, result: i.Result, err: i.Err, resultTime: time.Now()}
-		}
-	}
-	go res.rmWorker()
-	return res
+	return NewRequestMapBuilder[K, V]().WithContext(ctx).WithTTL(ttl).WithInitial(init...).Build()
+}
+
+// NewSingleFlightRequestMap returns RequestMap which only collapses concurrent calls of the same key,
+// the result is shared by the callers waiting for it and is not kept after the call completes
+func NewSingleFlightRequestMap[K comparable, V any](ctx context.Context) *RequestMap[K, V] {
+	return NewRequestMapBuilder[K, V]().WithContext(ctx).WithSingleFlight().Build()
 }
 
 func (rm *RequestMap[K, V]) rmWorker() {
 
-	do := func() {
+	do := func(queue *list.List) {
 		rm.mu.RLock()
-		if len(rm.resultSlice) == 0 {
-			rm.mu.RUnlock()
-			return
-		}
-		first := rm.resultSlice[0]
+		first := queue.Front()
+		expired := first != nil && !rm.clock.Now().Before(rm.response[first.Value.(K)].expireAt)
 		rm.mu.RUnlock()
-		if time.Since(first.resultTime) < rm.deleteAfter {
+		if !expired {
 			return
 		}
 
 		rm.mu.Lock()
 		defer rm.mu.Unlock()
-		for i, r := range rm.resultSlice {
-			if time.Since(r.resultTime) < rm.deleteAfter {
-				rm.resultSlice = rm.resultSlice[i:]
-				return
-			}
-			delete(rm.response, r.key)
-		}
+		rm.expireLocked(queue)
 	}
 
 	for {
@@ -78,112 +120,281 @@ func (rm *RequestMap[K, V]) rmWorker() {
 		case <-rm.ctx.Done():
 			return
 		case <-time.After(time.Millisecond * 100):
-			do()
+			do(rm.resultQueue)
+			do(rm.errorQueue)
 		}
 	}
 }
 
+// expireLocked removes the expired results from the head of the queue, the queue is ordered by the insertion time
+func (rm *RequestMap[K, V]) expireLocked(queue *list.List) {
+	now := rm.clock.Now()
+	for e := queue.Front(); e != nil; e = queue.Front() {
+		key := e.Value.(K)
+		if r, ok := rm.response[key]; ok && now.Before(r.expireAt) {
+			return
+		}
+		queue.Remove(e)
+		rm.deleteLocked(key)
+	}
+}
+
+// deleteLocked removes the stored result of key from the map, the lru list and the expiry queue
+func (rm *RequestMap[K, V]) deleteLocked(key K) {
+	if r, ok := rm.response[key]; ok {
+		if r.elem != nil {
+			rm.lru.Remove(r.elem)
+		}
+		if r.err != nil {
+			rm.errorQueue.Remove(r.queued)
+		} else {
+			rm.resultQueue.Remove(r.queued)
+		}
+		delete(rm.response, key)
+	}
+}
+
+// lookupLocked returns the not expired result of key and marks it as recently used. Must be called under rm.mu write lock
+func (rm *RequestMap[K, V]) lookupLocked(key K) (resultHolder[K, V], bool) {
+	r, ok := rm.response[key]
+	if !ok {
+		return r, false
+	}
+	if !rm.clock.Now().Before(r.expireAt) {
+		rm.deleteLocked(key)
+		return r, false
+	}
+	if r.elem != nil {
+		rm.lru.MoveToFront(r.elem)
+	}
+	return r, true
+}
+
 func (rm *RequestMap[K, V]) Count() int {
 	rm.mu.RLock()
 	defer rm.mu.RUnlock()
-	a := len(rm.waiters)
+	a := len(rm.calls)
 	b := len(rm.response)
 	return a + b
 }
 
-// GetOrCreate returns the value for the Key if it exists, otherwise it calls the function f and returns the Result
+// GetOrCreate returns the value for the Key if it exists, otherwise it calls the function f and returns the Result.
+// A panic in f is raised again in every caller as *RequestPanicError
 func (rm *RequestMap[K, V]) GetOrCreate(key K, f func(k K) V) V {
-	rm.mu.RLock()
-	v, ok := rm.response[key]
-	rm.mu.RUnlock()
-	if ok {
-		return v.result
+	ch, _ := rm.getOrCall(key, func(_ context.Context, k K) (V, error) { return f(k), nil })
+	res := <-ch
+	if p, ok := res.err.(*RequestPanicError); ok {
+		panic(p)
+	}
+	return res.result
+}
+
+// GetOrCreateWithErr returns the value for the Key if it exists, otherwise it calls the function f and returns the Result.
+// A panic in f is returned to every caller as *RequestPanicError
+func (rm *RequestMap[K, V]) GetOrCreateWithErr(key K, f func(k K) (V, error)) (V, error) {
+	ch, _ := rm.getOrCall(key, withoutCtx(f))
+	res := <-ch
+	return res.result, res.err
+}
+
+// GetOrCreateCtx works as GetOrCreateWithErr, f gets the context of the call, which is cancelled when all callers
+// waiting for it have gone away or the map context is done. When ctx of a caller is done it gets ErrTimeout or
+// ErrCancelled, the call goes on for other callers
+func (rm *RequestMap[K, V]) GetOrCreateCtx(ctx context.Context, key K, f func(ctx context.Context, k K) (V, error)) (V, error) {
+	ch, c := rm.getOrCall(key, f)
+	select {
+	case res := <-ch:
+		return res.result, res.err
+	case <-ctx.Done():
 	}
 	rm.mu.Lock()
-	v, ok = rm.response[key]
-	if ok {
-		rm.mu.Unlock()
-		return v.result
+	defer rm.mu.Unlock()
+	select {
+	case res := <-ch: // already completed
+		return res.result, res.err
+	default:
 	}
-	ch := make(chan resultHolder[K, V], 1)
-	_, ok = rm.waiters[key]
-	rm.waiters[key] = append(rm.waiters[key], ch)
-	rm.mu.Unlock()
-	if !ok { // Start goroutine
-		go func() {
-			vx := f(key)
-			rm.mu.Lock()
-			r := resultHolder[K, V]{key: key, result: vx, resultTime: time.Now()}
-			rm.response[key] = r
-			for _, c := range rm.waiters[key] {
-				c <- r
+	// the call is not looked up by key, it could be forgotten since
+	for i, w := range c.waiters {
+		if w != ch {
+			continue
+		}
+		c.waiters = append(c.waiters[:i], c.waiters[i+1:]...)
+		if len(c.waiters) == 0 {
+			// nobody waits for the result, it is not stored
+			c.forgotten = true
+			if rm.calls[key] == c {
+				delete(rm.calls, key)
 			}
-			delete(rm.waiters, key)
-			rm.mu.Unlock()
-			// Start remove goroutine
-			go func() {
-				//time.Sleep(rm.deleteAfter)
-				//rm.mu.Lock()
-				//defer rm.mu.Unlock()
-				//delete(rm.waiters, key)
-				//delete(rm.response, key)
-				rm.mu.Lock()
-				rm.resultSlice = append(rm.resultSlice, r)
-				rm.mu.Unlock()
-			}()
-		}()
+			c.cancel()
+		}
+		break
+	}
+	var v V
+	return v, ctxError(ctx, ErrTimeout, ErrCancelled)
+}
+
+// Forget drops the stored result of key, the callers waiting for the in-flight call still receive its result,
+// but the next callers start a new call and the result of the forgotten call is not stored
+func (rm *RequestMap[K, V]) Forget(key K) {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+	rm.deleteLocked(key)
+	if c, ok := rm.calls[key]; ok {
+		c.forgotten = true
+		delete(rm.calls, key)
+	}
+}
+
+// Refresh calls f for key even if the result is stored, the stored result is returned to other callers until
+// f completes and is replaced by the new one. Joins the in-flight call of key if there is one
+func (rm *RequestMap[K, V]) Refresh(key K, f func(k K) (V, error)) (V, error) {
+	rm.mu.Lock()
+	ch := make(chan resultHolder[K, V], 1)
+	if c, ok := rm.calls[key]; ok {
+		c.waiters = append(c.waiters, ch)
+		rm.mu.Unlock()
+	} else {
+		c = rm.newCall()
+		c.waiters = append(c.waiters, ch)
+		c.refresh = true
+		rm.calls[key] = c
+		rm.mu.Unlock()
+		go rm.call(key, c, withoutCtx(f))
 	}
 	res := <-ch
-	return res.result
+	return res.result, res.err
 }
 
-// GetOrCreateWithErr returns the value for the Key if it exists, otherwise it calls the function f and returns the Result
-func (rm *RequestMap[K, V]) GetOrCreateWithErr(key K, f func(k K) (V, error)) (V, error) {
-	rm.mu.RLock()
-	v, ok := rm.response[key]
-	rm.mu.RUnlock()
-	if ok {
-		return v.result, v.err
+// getOrCall returns the stored result of key in a ready channel or joins the in-flight call, starting it if needed.
+// The call is nil for a stored result
+func (rm *RequestMap[K, V]) getOrCall(key K, f func(ctx context.Context, k K) (V, error)) (chan resultHolder[K, V], *requestCall[K, V]) {
+	ch := make(chan resultHolder[K, V], 1)
+	if rm.lru == nil { // the lru order is updated under the write lock
+		rm.mu.RLock()
+		v, ok := rm.response[key]
+		rm.mu.RUnlock()
+		if ok && rm.clock.Now().Before(v.expireAt) {
+			ch <- v
+			return ch, nil
+		}
 	}
 	rm.mu.Lock()
-	v, ok = rm.response[key]
+	v, ok := rm.lookupLocked(key)
 	if ok {
 		rm.mu.Unlock()
-		return v.result, v.err
+		ch <- v
+		return ch, nil
 	}
-	ch := make(chan resultHolder[K, V], 1)
-	_, ok = rm.waiters[key]
-	rm.waiters[key] = append(rm.waiters[key], ch)
+	c, ok := rm.calls[key]
+	if !ok {
+		c = rm.newCall()
+		rm.calls[key] = c
+	}
+	c.waiters = append(c.waiters, ch)
 	rm.mu.Unlock()
 	if !ok { // Start goroutine
-		go func() {
-			vx, err := f(key)
-			rm.mu.Lock()
-			r := resultHolder[K, V]{key: key, result: vx, err: err, resultTime: time.Now()}
-			rm.response[key] = r
-			for _, c := range rm.waiters[key] {
-				c <- r
+		go rm.call(key, c, f)
+	}
+	return ch, c
+}
+
+func (rm *RequestMap[K, V]) newCall() *requestCall[K, V] {
+	ctx, cancel := context.WithCancel(rm.ctx)
+	return &requestCall[K, V]{ctx: ctx, cancel: cancel}
+}
+
+func withoutCtx[K comparable, V any](f func(k K) (V, error)) func(ctx context.Context, k K) (V, error) {
+	return func(_ context.Context, k K) (V, error) {
+		return f(k)
+	}
+}
+
+func (rm *RequestMap[K, V]) call(key K, c *requestCall[K, V], f func(ctx context.Context, k K) (V, error)) {
+	defer c.cancel()
+	r := resultHolder[K, V]{key: key}
+	func() {
+		defer func() {
+			if p := recover(); p != nil {
+				r.err = &RequestPanicError{Value: p, Stack: debug.Stack()}
 			}
-			delete(rm.waiters, key)
-			rm.mu.Unlock()
-			// Start remove goroutine
-			go func() {
-				//time.Sleep(rm.deleteAfter)
-				//rm.mu.Lock()
-				//defer rm.mu.Unlock()
-				//delete(rm.waiters, key)
-				//delete(rm.response, key)
-				rm.mu.Lock()
-				rm.resultSlice = append(rm.resultSlice, r)
-				rm.mu.Unlock()
-			}()
 		}()
+		r.result, r.err = retryCall(c.ctx, rm.retry, func(ctx context.Context) (V, error) {
+			return f(ctx, key)
+		})
+	}()
+	r.resultTime = rm.clock.Now()
+	rm.complete(c, r)
+}
+
+// complete releases the waiters of the call and stores the result, unless the map is single flight,
+// the call is forgotten or f panicked. The first stored result wins, except for Refresh
+func (rm *RequestMap[K, V]) complete(c *requestCall[K, V], r resultHolder[K, V]) {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+	rm.completeLocked(c, r)
+}
+
+func (rm *RequestMap[K, V]) completeLocked(c *requestCall[K, V], r resultHolder[K, V]) {
+	if rm.calls[r.key] == c {
+		delete(rm.calls, r.key)
 	}
-	res := <-ch
-	return res.result, res.err
+	for _, ch := range c.waiters {
+		ch <- r
+	}
+	c.waiters = nil
+	if rm.singleFlight || c.forgotten {
+		return
+	}
+	if _, ok := r.err.(*RequestPanicError); ok {
+		return
+	}
+	if _, ok := rm.lookupLocked(r.key); ok && !c.refresh {
+		return
+	}
+	rm.store(r)
 }
 
-// Timeout returns a function that will call f with k and return the Result or ErrTimeout if the duration is exceeded
+// store keeps the result for ttl and the error for error ttl, an error is not stored if error ttl is 0.
+// The least recently used result is evicted when the map is bounded and full
+func (rm *RequestMap[K, V]) store(r resultHolder[K, V]) {
+	queue := rm.resultQueue
+	r.expireAt = r.resultTime.Add(rm.deleteAfter)
+	if r.err != nil {
+		if rm.errorTTL <= 0 {
+			return
+		}
+		queue = rm.errorQueue
+		r.expireAt = r.resultTime.Add(rm.errorTTL)
+	}
+	rm.deleteLocked(r.key)
+	if rm.lru != nil {
+		r.elem = rm.lru.PushFront(r.key)
+		if rm.lru.Len() > rm.maxEntries {
+			rm.deleteLocked(rm.lru.Back().Value.(K))
+		}
+	}
+	r.queued = queue.PushBack(r.key)
+	rm.response[r.key] = r
+}
+
+// resolve completes the in-flight call of key with value, the first stored result wins
+func (rm *RequestMap[K, V]) resolve(key K, value V) {
+	r := resultHolder[K, V]{key: key, result: value, resultTime: rm.clock.Now()}
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+	if c, ok := rm.calls[key]; ok {
+		rm.completeLocked(c, r)
+		return
+	}
+	if _, ok := rm.lookupLocked(key); ok || rm.singleFlight {
+		return
+	}
+	rm.store(r)
+}
+
+// Timeout returns a function that will call f with k and return the Result or ErrTimeout if the duration is exceeded.
+// f keeps running after the timeout, use TimeoutCtx to stop it
 func (rm *RequestMap[K, V]) Timeout(duration time.Duration, f func(k K) (V, error)) func(k K) (V, error) {
 	return func(k K) (V, error) {
 		res := make(chan resultHolder[K, V], 1)
@@ -200,3 +411,163 @@ func (rm *RequestMap[K, V]) Timeout(duration time.Duration, f func(k K) (V, erro
 		}
 	}
 }
+
+// TimeoutCtx returns a function that will call f with a context cancelled after duration,
+// and return the Result or ErrTimeout if the duration is exceeded
+func (rm *RequestMap[K, V]) TimeoutCtx(duration time.Duration, f func(ctx context.Context, k K) (V, error)) func(ctx context.Context, k K) (V, error) {
+	return func(ctx context.Context, k K) (V, error) {
+		ctx, cancel := context.WithTimeout(ctx, duration)
+		defer cancel()
+		res := make(chan resultHolder[K, V], 1)
+		go func() {
+			v, err := f(ctx, k)
+			res <- resultHolder[K, V]{key: k, result: v, err: err}
+		}()
+		select {
+		case r := <-res:
+			return r.result, r.err
+		case <-ctx.Done():
+			var result V
+			return result, ctxError(ctx, ErrTimeout, ErrCancelled)
+		}
+	}
+}
+
+type RequestMapBuilder[K comparable, V any] struct {
+	ctx          context.Context
+	ttl          time.Duration
+	errorTTL     time.Duration
+	retry        *RetryPolicy
+	singleFlight bool
+	init         []*RequestMapInitializer[K, V]
+	maxEntries   int
+	clock        Clock
+}
+
+// RetryPolicy calls f again while it returns a retryable error, waiting for the backoff between attempts.
+// The backoff starts from InitialBackoff and is multiplied by Multiplier up to MaxBackoff
+type RetryPolicy struct {
+	// MaxAttempts is the number of calls including the first one
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// Multiplier of the backoff, 2 if not set
+	Multiplier float64
+	// Retryable reports if the error should be retried, all errors are retried if nil
+	Retryable func(err error) bool
+}
+
+func NewRequestMapBuilder[K comparable, V any]() *RequestMapBuilder[K, V] {
+	return &RequestMapBuilder[K, V]{
+		ctx:      context.Background(),
+		ttl:      time.Minute * 5,
+		errorTTL: -1,
+		clock:    systemClock{},
+	}
+}
+
+func (b *RequestMapBuilder[K, V]) WithContext(ctx context.Context) *RequestMapBuilder[K, V] {
+	b.ctx = ctx
+	return b
+}
+
+// WithTTL sets how long the results are kept, and the errors if WithErrorTTL is not set
+func (b *RequestMapBuilder[K, V]) WithTTL(ttl time.Duration) *RequestMapBuilder[K, V] {
+	b.ttl = ttl
+	return b
+}
+
+// WithErrorTTL sets how long the errors are kept, 0 means the errors are not kept and the next caller calls f again
+func (b *RequestMapBuilder[K, V]) WithErrorTTL(ttl time.Duration) *RequestMapBuilder[K, V] {
+	b.errorTTL = ttl
+	return b
+}
+
+func (b *RequestMapBuilder[K, V]) WithRetry(policy RetryPolicy) *RequestMapBuilder[K, V] {
+	b.retry = &policy
+	return b
+}
+
+// WithSingleFlight only collapses concurrent calls of the same key, the results are not kept
+func (b *RequestMapBuilder[K, V]) WithSingleFlight() *RequestMapBuilder[K, V] {
+	b.singleFlight = true
+	return b
+}
+
+// WithMaxEntries bounds the number of stored results, the least recently used result is evicted first
+func (b *RequestMapBuilder[K, V]) WithMaxEntries(n int) *RequestMapBuilder[K, V] {
+	b.maxEntries = n
+	return b
+}
+
+// WithClock sets the clock used for the result expiry, mostly for tests
+func (b *RequestMapBuilder[K, V]) WithClock(c Clock) *RequestMapBuilder[K, V] {
+	b.clock = c
+	return b
+}
+
+func (b *RequestMapBuilder[K, V]) WithInitial(init ...*RequestMapInitializer[K, V]) *RequestMapBuilder[K, V] {
+	b.init = append(b.init, init...)
+	return b
+}
+
+func (b *RequestMapBuilder[K, V]) Build() *RequestMap[K, V] {
+	errorTTL := b.errorTTL
+	if errorTTL < 0 {
+		errorTTL = b.ttl
+	}
+	res := &RequestMap[K, V]{
+		calls:        make(map[K]*requestCall[K, V]),
+		response:     make(map[K]resultHolder[K, V]),
+		deleteAfter:  b.ttl,
+		errorTTL:     errorTTL,
+		retry:        b.retry,
+		ctx:          b.ctx,
+		singleFlight: b.singleFlight,
+		clock:        b.clock,
+		maxEntries:   b.maxEntries,
+		resultQueue:  list.New(),
+		errorQueue:   list.New(),
+	}
+	if res.singleFlight {
+		return res
+	}
+	if res.maxEntries > 0 {
+		res.lru = list.New()
+	}
+	for _, i := range b.init {
+		res.store(resultHolder[K, V]{key: i.Key, result: i.Result, err: i.Err, resultTime: res.clock.Now()})
+	}
+	go res.rmWorker()
+	return res
+}
+
+// retryCall calls f until it succeeds, returns a not retryable error, the attempts are exhausted or ctx is done.
+// f is called once if the policy is nil
+func retryCall[V any](ctx context.Context, p *RetryPolicy, f func(ctx context.Context) (V, error)) (V, error) {
+	v, err := f(ctx)
+	if p == nil {
+		return v, err
+	}
+	multiplier := p.Multiplier
+	if multiplier <= 0 {
+		multiplier = 2
+	}
+	backoff := p.InitialBackoff
+	for attempt := 1; err != nil && attempt < p.MaxAttempts; attempt++ {
+		if p.Retryable != nil && !p.Retryable(err) {
+			return v, err
+		}
+		select {
+		case <-ctx.Done():
+			return v, err
+		case <-time.After(backoff):
+		}
+		v, err = f(ctx)
+		backoff = time.Duration(float64(backoff) * multiplier)
+		if p.MaxBackoff > 0 && backoff > p.MaxBackoff {
+			backoff = p.MaxBackoff
+		}
+	}
+	return v, err
+}
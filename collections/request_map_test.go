@@ -3,6 +3,7 @@ package collections
 import (
 	"context"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"github.com/stretchr/testify/assert"
 	"math/rand/v2"
@@ -260,3 +261,268 @@ func TestWaitMap_Billing3(t *testing.T) {
 	t.Log("count", wa.Count())
 	t.Log("delta goroutine", runtime.NumGoroutine()-startGor)
 }
+
+func TestRequestMap_SingleFlight(t *testing.T) {
+	rm := NewSingleFlightRequestMap[int, int](context.Background())
+	var calls atomic.Int32
+	f := func(k int) (int, error) {
+		time.Sleep(time.Millisecond * 20)
+		return int(calls.Add(1)), nil
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			v, err := rm.GetOrCreateWithErr(1, f)
+			assert.Nil(t, err)
+			assert.Equal(t, 1, v)
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 0, rm.Count())
+	// the result is not kept after the call
+	v, _ := rm.GetOrCreateWithErr(1, f)
+	assert.Equal(t, 2, v)
+}
+
+func TestRequestMap_ForgetRefresh(t *testing.T) {
+	rm := NewRequestMap[int, int](context.Background(), time.Minute)
+	var calls atomic.Int32
+	f := func(k int) (int, error) {
+		return int(calls.Add(1)), nil
+	}
+	v, _ := rm.GetOrCreateWithErr(1, f)
+	assert.Equal(t, 1, v)
+	v, _ = rm.GetOrCreateWithErr(1, f)
+	assert.Equal(t, 1, v)
+	rm.Forget(1)
+	v, _ = rm.GetOrCreateWithErr(1, f)
+	assert.Equal(t, 2, v)
+
+	v, _ = rm.Refresh(1, f)
+	assert.Equal(t, 3, v)
+	v, _ = rm.GetOrCreateWithErr(1, f)
+	assert.Equal(t, 3, v)
+}
+
+func TestRequestMap_Panic(t *testing.T) {
+	rm := NewRequestMap[int, int](context.Background(), time.Minute)
+	f := func(k int) (int, error) {
+		time.Sleep(time.Millisecond * 10)
+		panic("boom")
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := rm.GetOrCreateWithErr(1, f)
+			var p *RequestPanicError
+			assert.ErrorAs(t, err, &p)
+			assert.Equal(t, "boom", p.Value)
+		}()
+	}
+	wg.Wait()
+	// the panic is not cached
+	v, err := rm.GetOrCreateWithErr(1, func(k int) (int, error) { return 1, nil })
+	assert.Nil(t, err)
+	assert.Equal(t, 1, v)
+
+	assert.Panics(t, func() {
+		rm.GetOrCreate(2, func(k int) int { panic("boom") })
+	})
+}
+
+func TestRequestMap_GetOrCreateCtx(t *testing.T) {
+	rm := NewRequestMap[int, int](context.Background(), time.Minute)
+	var cancelled atomic.Int32
+	f := func(ctx context.Context, k int) (int, error) {
+		select {
+		case <-ctx.Done():
+			cancelled.Add(1)
+			return 0, ctx.Err()
+		case <-time.After(time.Millisecond * 50):
+			return k, nil
+		}
+	}
+	// a waiter deadline doesn't cancel the work for others
+	short, cancel := context.WithTimeout(context.Background(), time.Millisecond*10)
+	defer cancel()
+	done := make(chan int, 1)
+	go func() {
+		v, err := rm.GetOrCreateCtx(context.Background(), 1, f)
+		assert.Nil(t, err)
+		done <- v
+	}()
+	time.Sleep(time.Millisecond)
+	_, err := rm.GetOrCreateCtx(short, 1, f)
+	assert.ErrorIs(t, err, ErrTimeout)
+	assert.Equal(t, 1, <-done)
+	assert.Equal(t, int32(0), cancelled.Load())
+
+	// the work is cancelled when all waiters are gone
+	short, cancel = context.WithTimeout(context.Background(), time.Millisecond*10)
+	defer cancel()
+	_, err = rm.GetOrCreateCtx(short, 2, f)
+	assert.ErrorIs(t, err, ErrTimeout)
+	time.Sleep(time.Millisecond * 5)
+	assert.Equal(t, int32(1), cancelled.Load())
+	v, err := rm.GetOrCreateCtx(context.Background(), 2, f)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, v)
+
+	tFunc := rm.TimeoutCtx(time.Millisecond*10, f)
+	_, err = tFunc(context.Background(), 3)
+	assert.ErrorIs(t, err, ErrTimeout)
+	time.Sleep(time.Millisecond * 5)
+	assert.Equal(t, int32(2), cancelled.Load())
+}
+
+func TestRequestMap_ForgetThenCancel(t *testing.T) {
+	rm := NewRequestMap[int, int](context.Background(), time.Minute)
+	cancelled := make(chan struct{})
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(time.Millisecond * 10)
+		rm.Forget(1)
+		cancel()
+	}()
+	_, err := rm.GetOrCreateCtx(ctx, 1, func(ctx context.Context, k int) (int, error) {
+		select {
+		case <-ctx.Done():
+			close(cancelled)
+			return 0, ctx.Err()
+		case <-time.After(time.Second):
+			return k, nil
+		}
+	})
+	assert.ErrorIs(t, err, ErrCancelled)
+	// the forgotten call is cancelled when its last waiter is gone
+	select {
+	case <-cancelled:
+	case <-time.After(time.Millisecond * 100):
+		t.Fatal("forgotten call was not cancelled")
+	}
+}
+
+func TestRequestMapBuilder_ErrorTTL(t *testing.T) {
+	failed := errors.New("failed")
+	var calls atomic.Int32
+	f := func(k int) (int, error) {
+		if calls.Add(1) == 1 {
+			return 0, failed
+		}
+		return k, nil
+	}
+	rm := NewRequestMapBuilder[int, int]().WithTTL(time.Minute).WithErrorTTL(0).Build()
+	_, err := rm.GetOrCreateWithErr(1, f)
+	assert.ErrorIs(t, err, failed)
+	v, err := rm.GetOrCreateWithErr(1, f)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, v)
+
+	calls.Store(0)
+	rm = NewRequestMapBuilder[int, int]().WithTTL(time.Minute).WithErrorTTL(time.Millisecond * 50).Build()
+	_, err = rm.GetOrCreateWithErr(1, f)
+	assert.ErrorIs(t, err, failed)
+	_, err = rm.GetOrCreateWithErr(1, f)
+	assert.ErrorIs(t, err, failed)
+	time.Sleep(time.Millisecond * 200)
+	v, err = rm.GetOrCreateWithErr(1, f)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, v)
+}
+
+func TestRequestMapBuilder_Retry(t *testing.T) {
+	failed := errors.New("failed")
+	fatal := errors.New("fatal")
+	var calls atomic.Int32
+	rm := NewRequestMapBuilder[int, int]().WithErrorTTL(0).WithRetry(RetryPolicy{
+		MaxAttempts:    3,
+		InitialBackoff: time.Millisecond,
+		Retryable:      func(err error) bool { return !errors.Is(err, fatal) },
+	}).Build()
+	v, err := rm.GetOrCreateWithErr(1, func(k int) (int, error) {
+		if calls.Add(1) < 3 {
+			return 0, failed
+		}
+		return k, nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, v)
+	assert.Equal(t, int32(3), calls.Load())
+
+	calls.Store(0)
+	_, err = rm.GetOrCreateWithErr(2, func(k int) (int, error) {
+		calls.Add(1)
+		return 0, failed
+	})
+	assert.ErrorIs(t, err, failed)
+	assert.Equal(t, int32(3), calls.Load())
+
+	calls.Store(0)
+	_, err = rm.GetOrCreateWithErr(3, func(k int) (int, error) {
+		calls.Add(1)
+		return 0, fatal
+	})
+	assert.ErrorIs(t, err, fatal)
+	assert.Equal(t, int32(1), calls.Load())
+}
+
+type testClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *testClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *testClock) Add(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func TestRequestMapBuilder_MaxEntriesAndClock(t *testing.T) {
+	clock := &testClock{now: time.Now()}
+	var calls atomic.Int32
+	f := func(k int) (int, error) {
+		calls.Add(1)
+		return k, nil
+	}
+	rm := NewRequestMapBuilder[int, int]().WithTTL(time.Minute).WithMaxEntries(2).WithClock(clock).
+		WithInitial(&RequestMapInitializer[int, int]{Key: 1, Result: 10}).Build()
+	v, _ := rm.GetOrCreateWithErr(1, f)
+	assert.Equal(t, 10, v)
+	_, _ = rm.GetOrCreateWithErr(2, f)
+	// 1 is used recently, 2 is evicted
+	_, _ = rm.GetOrCreateWithErr(1, f)
+	_, _ = rm.GetOrCreateWithErr(3, f)
+	assert.Equal(t, 2, rm.Count())
+	assert.Equal(t, int32(2), calls.Load())
+	v, _ = rm.GetOrCreateWithErr(1, f)
+	assert.Equal(t, 10, v)
+	_, _ = rm.GetOrCreateWithErr(2, f)
+	assert.Equal(t, int32(3), calls.Load())
+
+	clock.Add(time.Minute)
+	v, _ = rm.GetOrCreateWithErr(1, f)
+	assert.Equal(t, 1, v)
+	assert.Equal(t, int32(4), calls.Load())
+}
+
+func TestRequestMapBuilder_MaxEntriesBoundsQueue(t *testing.T) {
+	rm := NewRequestMapBuilder[int, []byte]().WithTTL(time.Hour).WithMaxEntries(10).Build()
+	for i := 0; i < 10000; i++ {
+		_ = rm.GetOrCreate(i, func(k int) []byte { return make([]byte, 1024) })
+	}
+	rm.mu.RLock()
+	defer rm.mu.RUnlock()
+	assert.Equal(t, 10, len(rm.response))
+	// the evicted results are not held by the expiry queue until ttl
+	assert.LessOrEqual(t, rm.resultQueue.Len(), 10)
+}
@@ -20,6 +20,8 @@ type ResponseMapBuilder[K comparable, V any] struct {
 	logger          zerolog.Logger
 	responseTimeout time.Duration
 	clearTimeout    time.Duration
+	scheduler       *Scheduler
+	hooks           WaitHooks[K]
 }
 
 func NewResponseMap[K comparable, V any](ctx context.Context) *ResponseMapBuilder[K, V] {
@@ -45,107 +47,163 @@ func (b *ResponseMapBuilder[K, V]) WithClearTimeout(timeout time.Duration) *Resp
 	return b
 }
 
+func (b *ResponseMapBuilder[K, V]) WithHooks(hooks WaitHooks[K]) *ResponseMapBuilder[K, V] {
+	b.hooks = hooks
+	return b
+}
+
+// WithScheduler sets the scheduler of the response timeouts, DefaultScheduler by default
+func (b *ResponseMapBuilder[K, V]) WithScheduler(s *Scheduler) *ResponseMapBuilder[K, V] {
+	b.scheduler = s
+	return b
+}
+
 func (b *ResponseMapBuilder[K, V]) Build() *ResponseMap[K, V] {
+	if b.scheduler == nil {
+		b.scheduler = DefaultScheduler()
+	}
 	rm := &ResponseMap[K, V]{
+		ctx:             b.ctx,
 		logger:          b.logger,
 		responseTimeout: b.responseTimeout,
 		clearTimeout:    b.clearTimeout,
+		scheduler:       b.scheduler,
+		observer:        newWaitObserver(b.hooks),
 		mu:              sync.RWMutex{},
 		m:               make(map[K]*chansHolder[K, V]),
+		streams:         make(map[K]*streamHolder[K, V]),
 	}
 	go rm.clear(b.ctx)
 	return rm
 }
 
 type ResponseMap[K comparable, V any] struct {
+	ctx             context.Context
 	logger          zerolog.Logger
 	responseTimeout time.Duration
 	clearTimeout    time.Duration
+	scheduler       *Scheduler
+	observer        *waitObserver[K]
 	mu              sync.RWMutex
 	m               map[K]*chansHolder[K, V]
+	streams         map[K]*streamHolder[K, V]
 }
 
 type chansHolder[K comparable, V any] struct {
-	ctx       context.Context
-	cancelCtx context.CancelFunc
 	trx       K
-	t         *time.Timer
+	t         *ScheduledTask
 	createdAt time.Time
+	observer  *waitObserver[K]
 	mu        sync.RWMutex
 	isExist   bool
+	timedOut  bool
+	cancelled bool
 	data      V
-	dataCh    chan V
 	listeners []chan V
 }
 
-func newChansHolder[K comparable, V any](trx K, timeout time.Duration) *chansHolder[K, V] {
-	ctx, cancel := context.WithCancel(context.Background())
+func newChansHolder[K comparable, V any](trx K, timeout time.Duration, scheduler *Scheduler, observer *waitObserver[K]) *chansHolder[K, V] {
 	h := &chansHolder[K, V]{
-		ctx:       ctx,
-		cancelCtx: cancel,
 		trx:       trx,
-		t:         time.NewTimer(timeout),
 		createdAt: time.Now(),
-		dataCh:    make(chan V, 1),
-	}
-	go func() {
-		defer h.t.Stop()
-		for {
-			select {
-			case <-h.ctx.Done():
-				close(h.dataCh)
-				for _, ch := range h.listeners {
-					close(ch)
-				}
-				return
-			case <-h.t.C:
-				for _, ch := range h.listeners {
-					close(ch)
-				}
-				h.mu.Lock()
-				h.listeners = nil
-				h.mu.Unlock()
-			case d := <-h.dataCh:
-				h.mu.Lock()
-				h.data = d
-				h.isExist = true
-				for _, ch := range h.listeners {
-					ch <- d
-				}
-				h.mu.Unlock()
-			}
-		}
-	}()
+		observer:  observer,
+	}
+	h.t = scheduler.Schedule(timeout, h.timeout)
+	observer.onCreated(trx)
 	return h
 }
 
+func (h *chansHolder[K, V]) timeout() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.isExist || h.cancelled {
+		return
+	}
+	h.timedOut = true
+	h.observer.onTimeout(h.trx)
+	h.closeListeners()
+}
+
+// cancelCtx releases the waiters of the holder removed by the cleaner
+func (h *chansHolder[K, V]) cancelCtx() {
+	h.t.Cancel()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.cancelled = true
+	h.closeListeners()
+	h.observer.onExpired(h.trx)
+}
+
+func (h *chansHolder[K, V]) closeListeners() {
+	for _, ch := range h.listeners {
+		close(ch)
+	}
+	h.listeners = nil
+}
+
 func (h *chansHolder[K, V]) set(data V) {
-	h.mu.RLock()
-	exist := h.isExist
-	h.mu.RUnlock()
-	if exist {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.isExist || h.cancelled {
 		return
 	}
-	h.dataCh <- data
+	h.t.Cancel()
+	h.data = data
+	h.isExist = true
+	h.observer.onResolved(h.trx, h.createdAt)
+	for _, ch := range h.listeners {
+		ch <- data
+	}
+	h.listeners = nil
 }
 
-func (h *chansHolder[K, V]) wait() V {
+func (h *chansHolder[K, V]) wait(ctx context.Context) (V, error) {
 	h.mu.RLock()
 	e := h.isExist
 	h.mu.RUnlock()
 	if e {
-		return h.data
+		return h.data, nil
 	}
 	h.mu.Lock()
-	e = h.isExist
-	if e {
+	if h.isExist {
 		h.mu.Unlock()
-		return h.data
+		return h.data, nil
+	}
+	var v V
+	if h.timedOut {
+		h.mu.Unlock()
+		return v, ErrTimeout
 	}
 	ch := make(chan V, 1)
 	h.listeners = append(h.listeners, ch)
 	h.mu.Unlock()
-	return <-ch
+	h.observer.pending.Add(1)
+	defer h.observer.pending.Add(-1)
+	select {
+	case d, ok := <-ch:
+		if ok {
+			return d, nil
+		}
+		h.mu.RLock()
+		defer h.mu.RUnlock()
+		if h.cancelled { // holder was cleaned before the response or the timeout
+			return v, ErrCancelled
+		}
+		return v, ErrTimeout
+	case <-ctx.Done():
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		if h.isExist {
+			return h.data, nil
+		}
+		for i, l := range h.listeners {
+			if l == ch {
+				h.listeners = append(h.listeners[:i], h.listeners[i+1:]...)
+				break
+			}
+		}
+		return v, ctxError(ctx, ErrTimeout, ErrCancelled)
+	}
 }
 
 func (r *ResponseMap[K, V]) Set(key K, value V) {
@@ -153,9 +211,28 @@ func (r *ResponseMap[K, V]) Set(key K, value V) {
 	holder.set(value)
 }
 
+func (r *ResponseMap[K, V]) Stats() WaitStats {
+	return r.observer.stats()
+}
+
+// Wait returns the value of key, or zero value when the response timeout is exceeded
 func (r *ResponseMap[K, V]) Wait(key K) V {
+	v, _ := r.getHolder(key).wait(r.ctx)
+	return v
+}
+
+// WaitCtx works as Wait, but also stops waiting when ctx is done without affecting other waiters of key.
+// Returns ErrTimeout when the response timeout or ctx deadline is exceeded, ErrCancelled when ctx or the map context is cancelled
+func (r *ResponseMap[K, V]) WaitCtx(ctx context.Context, key K) (V, error) {
 	holder := r.getHolder(key)
-	return holder.wait()
+	if r.ctx.Done() == nil {
+		return holder.wait(ctx)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stop := context.AfterFunc(r.ctx, cancel)
+	defer stop()
+	return holder.wait(ctx)
 }
 
 func (r *ResponseMap[K, V]) getHolder(key K) *chansHolder[K, V] {
@@ -171,7 +248,7 @@ func (r *ResponseMap[K, V]) getHolder(key K) *chansHolder[K, V] {
 	if ok {
 		return holder
 	}
-	holder = newChansHolder[K, V](key, r.responseTimeout)
+	holder = newChansHolder[K, V](key, r.responseTimeout, r.scheduler, r.observer)
 	r.m[key] = holder
 	return holder
 }
@@ -200,6 +277,7 @@ func (r *ResponseMap[K, V]) clear(ctx context.Context) {
 				delete(r.m, holder.trx)
 			}
 			r.mu.Unlock()
+			r.clearStreams()
 			r.logger.Debug().Int("holders count", len(r.m)).Msg("successfully cleaned expired holders")
 		}
 	}
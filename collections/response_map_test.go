@@ -2,10 +2,12 @@ package collections
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/rs/zerolog"
 	"github.com/stretchr/testify/assert"
 	"os"
+	"runtime"
 	"sync"
 	"sync/atomic"
 	"testing"
@@ -134,3 +136,138 @@ func TestResponseMap_StuckOnDataChan(t *testing.T) {
 	}
 	wg.Wait()
 }
+
+func TestResponseMap_WaitCtx(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	m := NewResponseMap[string, string](ctx).WithResponseTimeout(time.Millisecond * 50).Build()
+	waitCtx, waitCancel := context.WithTimeout(context.Background(), time.Millisecond*10)
+	defer waitCancel()
+	done := make(chan string, 1)
+	go func() {
+		v, err := m.WaitCtx(context.Background(), "key")
+		assert.Nil(t, err)
+		done <- v
+	}()
+	_, err := m.WaitCtx(waitCtx, "key")
+	assert.True(t, errors.Is(err, ErrTimeout))
+	m.Set("key", "value")
+	assert.Equal(t, "value", <-done)
+
+	_, err = m.WaitCtx(context.Background(), "timeout")
+	assert.True(t, errors.Is(err, ErrTimeout))
+	// the holder remembers the timeout
+	_, err = m.WaitCtx(context.Background(), "timeout")
+	assert.True(t, errors.Is(err, ErrTimeout))
+
+	go func() {
+		time.Sleep(time.Millisecond * 10)
+		cancel()
+	}()
+	_, err = m.WaitCtx(context.Background(), "cancelled")
+	assert.True(t, errors.Is(err, ErrCancelled))
+}
+
+func TestResponseMap_Stream(t *testing.T) {
+	m := NewResponseMap[string, int](context.Background()).WithResponseTimeout(time.Second).Build()
+	assert.Nil(t, m.Publish("key", 1))
+	first := m.Subscribe(context.Background(), "key")
+	second := m.Subscribe(context.Background(), "key")
+	go func() {
+		for i := 2; i <= 5; i++ {
+			assert.Nil(t, m.Publish("key", i))
+		}
+		assert.Nil(t, m.Complete("key", nil))
+	}()
+	for _, ch := range []<-chan int{first, second} {
+		var got []int
+		for v := range ch {
+			got = append(got, v)
+		}
+		assert.Equal(t, []int{1, 2, 3, 4, 5}, got)
+	}
+	assert.Nil(t, m.Err("key"))
+	assert.True(t, errors.Is(m.Publish("key", 6), ErrStreamCompleted))
+
+	// late subscriber receives the history
+	var got []int
+	for v := range m.Subscribe(context.Background(), "key") {
+		got = append(got, v)
+	}
+	assert.Equal(t, []int{1, 2, 3, 4, 5}, got)
+
+	failed := errors.New("failed")
+	assert.Nil(t, m.Complete("failed", failed))
+	assert.Equal(t, failed, m.Err("failed"))
+}
+
+func TestResponseMap_StreamTimeout(t *testing.T) {
+	m := NewResponseMap[string, int](context.Background()).WithResponseTimeout(time.Millisecond * 20).
+		WithClearTimeout(time.Millisecond * 50).Build()
+	ch := m.Subscribe(context.Background(), "key")
+	assert.Nil(t, m.Publish("key", 1))
+	assert.Equal(t, 1, <-ch)
+	_, ok := <-ch
+	assert.False(t, ok)
+	assert.True(t, errors.Is(m.Err("key"), ErrTimeout))
+	time.Sleep(time.Millisecond * 120)
+	m.mu.RLock()
+	assert.Empty(t, m.streams)
+	m.mu.RUnlock()
+}
+
+func TestResponseMap_StreamAbandonedSubscriber(t *testing.T) {
+	m := NewResponseMap[string, int](context.Background()).WithResponseTimeout(time.Minute).Build()
+	assert.Nil(t, m.Publish("key", 1))
+	before := runtime.NumGoroutine()
+	ctx, cancel := context.WithCancel(context.Background())
+	ch := m.Subscribe(ctx, "key")
+	assert.Nil(t, m.Publish("key", 2))
+	// the subscriber stops reading before the stream is completed
+	cancel()
+	for i := 0; i < 100 && runtime.NumGoroutine() > before; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	assert.LessOrEqual(t, runtime.NumGoroutine(), before)
+	for range ch {
+	}
+}
+
+// BenchmarkResponseMap_Goroutines waits and sets distinct keys, the goroutine count doesn't depend on the number of keys
+func BenchmarkResponseMap_Goroutines(b *testing.B) {
+	m := NewResponseMap[int, int](context.Background()).WithResponseTimeout(time.Second).WithClearTimeout(time.Minute).Build()
+	before := runtime.NumGoroutine()
+	var wg sync.WaitGroup
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		wg.Add(1)
+		go func(key int) {
+			defer wg.Done()
+			m.Wait(key)
+		}(i)
+		m.Set(i, i)
+	}
+	wg.Wait()
+	b.StopTimer()
+	b.ReportMetric(float64(len(m.m)), "keys")
+	b.ReportMetric(float64(runtime.NumGoroutine()-before), "goroutines")
+}
+
+func TestResponseMap_Stats(t *testing.T) {
+	var resolved atomic.Int32
+	m := NewResponseMap[string, int](context.Background()).WithResponseTimeout(time.Millisecond * 20).
+		WithHooks(WaitHooks[string]{OnResolved: func(key string, latency time.Duration) { resolved.Add(1) }}).Build()
+	go func() {
+		time.Sleep(time.Millisecond * 5)
+		assert.Equal(t, int64(1), m.Stats().Pending)
+		m.Set("key", 1)
+	}()
+	assert.Equal(t, 1, m.Wait("key"))
+	_, err := m.WaitCtx(context.Background(), "timeout")
+	assert.True(t, errors.Is(err, ErrTimeout))
+	stats := m.Stats()
+	assert.Equal(t, int64(0), stats.Pending)
+	assert.Equal(t, uint64(2), stats.Created)
+	assert.Equal(t, uint64(1), stats.Resolved)
+	assert.Equal(t, uint64(1), stats.TimedOut)
+	assert.Equal(t, int32(1), resolved.Load())
+}
@@ -0,0 +1,166 @@
+package collections
+
+import (
+	"context"
+	"github.com/go-errors/errors"
+	"time"
+)
+
+/*
+ __    _           ___
+|  |  |_|_____ ___|_  |
+|  |__| |     | .'|  _|
+|_____|_|_|_|_|__,|___|
+zed (11.04.2024)
+*/
+
+var ErrStreamCompleted = errors.New("stream completed")
+
+// streamHolder keeps all published values of a key, every subscriber reads them from the start
+type streamHolder[K comparable, V any] struct {
+	ctx         context.Context
+	cancelCtx   context.CancelFunc
+	trx         K
+	t           *ScheduledTask
+	history     []V
+	changed     chan struct{}
+	done        bool
+	err         error
+	createdAt   time.Time
+	completedAt time.Time
+}
+
+// Subscribe returns a channel with every value published for key in order, including the values published before
+// Subscribe. The channel is closed when the stream is completed, timed out or removed by the cleaner, see Err.
+// A subscriber that stops reading must cancel ctx to release the channel
+func (r *ResponseMap[K, V]) Subscribe(ctx context.Context, key K) <-chan V {
+	h := r.getStream(key)
+	out := make(chan V)
+	go r.pump(ctx, h, out)
+	return out
+}
+
+// Publish appends the value to the stream of key, returns ErrStreamCompleted after Complete or timeout
+func (r *ResponseMap[K, V]) Publish(key K, value V) error {
+	h := r.getStream(key)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if h.done {
+		return ErrStreamCompleted
+	}
+	h.history = append(h.history, value)
+	close(h.changed)
+	h.changed = make(chan struct{})
+	return nil
+}
+
+// Complete finishes the stream of key with err, subscribers receive the remaining values and their channels are closed
+func (r *ResponseMap[K, V]) Complete(key K, err error) error {
+	h := r.getStream(key)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.completeLocked(h, err)
+}
+
+// Err returns the error the stream of key is completed with, ErrTimeout if the response timeout is exceeded,
+// nil while the stream is open, completed without error or not found
+func (r *ResponseMap[K, V]) Err(key K) error {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if h, ok := r.streams[key]; ok {
+		return h.err
+	}
+	return nil
+}
+
+func (r *ResponseMap[K, V]) completeLocked(h *streamHolder[K, V], err error) error {
+	if h.done {
+		return ErrStreamCompleted
+	}
+	h.t.Cancel()
+	h.done = true
+	h.err = err
+	h.completedAt = time.Now()
+	close(h.changed)
+	if errors.Is(err, ErrTimeout) {
+		r.observer.onTimeout(h.trx)
+	} else {
+		r.observer.onResolved(h.trx, h.createdAt)
+	}
+	return nil
+}
+
+func (r *ResponseMap[K, V]) getStream(key K) *streamHolder[K, V] {
+	r.mu.RLock()
+	h, ok := r.streams[key]
+	r.mu.RUnlock()
+	if ok {
+		return h
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if h, ok = r.streams[key]; ok {
+		return h
+	}
+	ctx, cancel := context.WithCancel(r.ctx)
+	h = &streamHolder[K, V]{
+		ctx:       ctx,
+		cancelCtx: cancel,
+		trx:       key,
+		changed:   make(chan struct{}),
+		createdAt: time.Now(),
+	}
+	h.t = r.scheduler.Schedule(r.responseTimeout, func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		_ = r.completeLocked(h, ErrTimeout)
+	})
+	r.streams[key] = h
+	r.observer.onCreated(key)
+	return h
+}
+
+func (r *ResponseMap[K, V]) pump(ctx context.Context, h *streamHolder[K, V], out chan V) {
+	defer close(out)
+	for i := 0; ; {
+		r.mu.RLock()
+		if i < len(h.history) {
+			v := h.history[i]
+			r.mu.RUnlock()
+			i++
+			select {
+			case out <- v:
+			case <-h.ctx.Done():
+				return
+			case <-ctx.Done():
+				return
+			}
+			continue
+		}
+		done, changed := h.done, h.changed
+		r.mu.RUnlock()
+		if done {
+			return
+		}
+		select {
+		case <-changed:
+		case <-h.ctx.Done():
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// clearStreams removes the streams completed more than clearTimeout ago
+func (r *ResponseMap[K, V]) clearStreams() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for key, h := range r.streams {
+		if h.done && time.Since(h.completedAt) > r.clearTimeout {
+			h.cancelCtx()
+			delete(r.streams, key)
+			r.observer.onExpired(key)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package collections
+
+import (
+	"container/heap"
+	"sync"
+	"time"
+)
+
+/*
+   ________  ________   _______   ______    ______   _______    _______
+  /        \/        \//       \//      \ //      \ /       \\//       \
+ /        _/        _//        //       ///       //        ///        /
+/-        //       //        _/        //        //         /        _/
+\_______// \_____// \________/\________/\________/\___/____/\____/___/
+zed (03.11.2024)
+*/
+
+// Scheduler runs delayed tasks from a single goroutine ordered by a heap, instead of a goroutine or a timer per task.
+// The goroutine is started by Schedule and exits when there are no tasks left.
+// Tasks are called one by one, so they must be short and must not block
+type Scheduler struct {
+	mu      sync.Mutex
+	tasks   scheduledTasks
+	wake    chan struct{}
+	running bool
+}
+
+type ScheduledTask struct {
+	at    time.Time
+	fn    func()
+	index int
+	s     *Scheduler
+}
+
+var (
+	defaultScheduler     *Scheduler
+	defaultSchedulerOnce sync.Once
+)
+
+func NewScheduler() *Scheduler {
+	return &Scheduler{wake: make(chan struct{}, 1)}
+}
+
+// DefaultScheduler returns the scheduler shared by the maps built without WithScheduler
+func DefaultScheduler() *Scheduler {
+	defaultSchedulerOnce.Do(func() {
+		defaultScheduler = NewScheduler()
+	})
+	return defaultScheduler
+}
+
+// Schedule calls fn after d
+func (s *Scheduler) Schedule(d time.Duration, fn func()) *ScheduledTask {
+	t := &ScheduledTask{at: time.Now().Add(d), fn: fn, s: s}
+	s.mu.Lock()
+	heap.Push(&s.tasks, t)
+	first := t.index == 0
+	if !s.running {
+		s.running = true
+		go s.run()
+	}
+	s.mu.Unlock()
+	if first {
+		select {
+		case s.wake <- struct{}{}:
+		default:
+		}
+	}
+	return t
+}
+
+// Cancel removes the task, returns false if the task is already called or cancelled
+func (t *ScheduledTask) Cancel() bool {
+	t.s.mu.Lock()
+	defer t.s.mu.Unlock()
+	if t.index < 0 {
+		return false
+	}
+	heap.Remove(&t.s.tasks, t.index)
+	return true
+}
+
+// Len returns the number of pending tasks
+func (s *Scheduler) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.tasks)
+}
+
+func (s *Scheduler) run() {
+	var timer *time.Timer
+	defer func() {
+		if timer != nil {
+			timer.Stop()
+		}
+	}()
+	for {
+		s.mu.Lock()
+		now := time.Now()
+		var due []*ScheduledTask
+		for len(s.tasks) > 0 && !s.tasks[0].at.After(now) {
+			due = append(due, heap.Pop(&s.tasks).(*ScheduledTask))
+		}
+		if len(due) == 0 && len(s.tasks) == 0 {
+			s.running = false
+			s.mu.Unlock()
+			return
+		}
+		var wait time.Duration
+		if len(s.tasks) > 0 {
+			wait = s.tasks[0].at.Sub(now)
+		}
+		s.mu.Unlock()
+		for _, t := range due {
+			t.fn()
+		}
+		if len(due) > 0 {
+			continue
+		}
+		if timer == nil {
+			timer = time.NewTimer(wait)
+		} else {
+			timer.Reset(wait)
+		}
+		select {
+		case <-timer.C:
+		case <-s.wake:
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+		}
+	}
+}
+
+type scheduledTasks []*ScheduledTask
+
+func (h scheduledTasks) Len() int           { return len(h) }
+func (h scheduledTasks) Less(i, j int) bool { return h[i].at.Before(h[j].at) }
+func (h scheduledTasks) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *scheduledTasks) Push(x any) {
+	t := x.(*ScheduledTask)
+	t.index = len(*h)
+	*h = append(*h, t)
+}
+
+func (h *scheduledTasks) Pop() any {
+	old := *h
+	n := len(old)
+	t := old[n-1]
+	old[n-1] = nil
+	t.index = -1
+	*h = old[:n-1]
+	return t
+}
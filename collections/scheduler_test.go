@@ -0,0 +1,42 @@
+package collections
+
+import (
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+	"time"
+)
+
+/*
+   ________  ________   _______   ______    ______   _______    _______
+  /        \/        \//       \//      \ //      \ /       \\//       \
+ /        _/        _//        //       ///       //        ///        /
+/-        //       //        _/        //        //         /        _/
+\_______// \_____// \________/\________/\________/\___/____/\____/___/
+zed (03.11.2024)
+*/
+
+func TestScheduler(t *testing.T) {
+	s := NewScheduler()
+	var mu sync.Mutex
+	var order []int
+	add := func(i int) func() {
+		return func() {
+			mu.Lock()
+			order = append(order, i)
+			mu.Unlock()
+		}
+	}
+	s.Schedule(time.Millisecond*30, add(3))
+	s.Schedule(time.Millisecond*10, add(1))
+	cancelled := s.Schedule(time.Millisecond*20, add(2))
+	s.Schedule(time.Millisecond*20, add(4))
+	assert.True(t, cancelled.Cancel())
+	assert.False(t, cancelled.Cancel())
+	assert.Equal(t, 3, s.Len())
+	time.Sleep(time.Millisecond * 60)
+	mu.Lock()
+	assert.Equal(t, []int{1, 4, 3}, order)
+	mu.Unlock()
+	assert.Equal(t, 0, s.Len())
+}
@@ -0,0 +1,25 @@
+//go:build !go1.24
+
+package collections
+
+import (
+	"hash/maphash"
+	"reflect"
+)
+
+/*
+ __    _           ___
+|  |  |_|_____ ___|_  |
+|  |__| |     | .'|  _|
+|_____|_|_|_|_|__,|___|
+zed (11.04.2024)
+*/
+
+func comparableHasher[K comparable]() func(K) uint64 {
+	return func(k K) uint64 {
+		var h maphash.Hash
+		h.SetSeed(shardSeed)
+		hashValue(&h, reflect.ValueOf(&k).Elem())
+		return h.Sum64()
+	}
+}
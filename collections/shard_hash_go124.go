@@ -0,0 +1,17 @@
+//go:build go1.24
+
+package collections
+
+import "hash/maphash"
+
+/*
+ __    _           ___
+|  |  |_|_____ ___|_  |
+|  |__| |     | .'|  _|
+|_____|_|_|_|_|__,|___|
+zed (11.04.2024)
+*/
+
+func comparableHasher[K comparable]() func(K) uint64 {
+	return func(k K) uint64 { return maphash.Comparable(shardSeed, k) }
+}
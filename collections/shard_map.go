@@ -1,6 +1,11 @@
 package collections
 
-import "sync"
+import (
+	"context"
+	"iter"
+	"sync"
+	"time"
+)
 
 /*
  __    _           ___
@@ -15,28 +20,55 @@ type ShardMap[K comparable, V any] struct {
 	shardsLock  []sync.RWMutex
 	shardsFunc  func(K) int
 	shardsCount int
+
+	// expiries are allocated per shard by SetWithTTL, a nil shard expiry costs a single check
+	expiries        []*shardExpiry[K]
+	onEvict         func(key K, value V)
+	clock           Clock
+	ctx             context.Context
+	janitorInterval time.Duration
+	janitorOnce     sync.Once
+	watch           watchHub[K, V]
 }
 
 func (m *ShardMap[K, V]) Get(key K) (V, bool) {
 	shard := m.shardsFunc(key)
 	m.shardsLock[shard].RLock()
 	defer m.shardsLock[shard].RUnlock()
-	v, ok := m.shards[shard][key]
-	return v, ok
+	return m.lookupLocked(shard, key)
 }
 
 func (m *ShardMap[K, V]) Set(key K, value V) {
 	shard := m.shardsFunc(key)
 	m.shardsLock[shard].Lock()
 	defer m.shardsLock[shard].Unlock()
-	m.shards[shard][key] = value
+	m.setLocked(shard, key, value)
 }
 
 func (m *ShardMap[K, V]) Delete(key K) {
 	shard := m.shardsFunc(key)
 	m.shardsLock[shard].Lock()
 	defer m.shardsLock[shard].Unlock()
+	m.deleteLocked(shard, key)
+}
+
+func (m *ShardMap[K, V]) setLocked(shard int, key K, value V) {
+	if m.watch.watching() {
+		old, existed := m.lookupLocked(shard, key)
+		defer m.watch.notifySet(key, old, existed, value)
+	}
+	m.shards[shard][key] = value
+	m.dropDeadlineLocked(shard, key)
+}
+
+func (m *ShardMap[K, V]) deleteLocked(shard int, key K) {
+	if m.watch.watching() {
+		if old, ok := m.lookupLocked(shard, key); ok {
+			defer m.watch.notifyDelete(key, old)
+		}
+	}
 	delete(m.shards[shard], key)
+	m.dropDeadlineLocked(shard, key)
 }
 
 func (m *ShardMap[K, V]) Has(key K) bool {
@@ -44,6 +76,9 @@ func (m *ShardMap[K, V]) Has(key K) bool {
 	m.shardsLock[shard].RLock()
 	defer m.shardsLock[shard].RUnlock()
 	_, ok := m.shards[shard][key]
+	if ok && m.expiries[shard] != nil {
+		return !m.expiredLocked(shard, key, m.clock.Now())
+	}
 	return ok
 }
 
@@ -51,10 +86,14 @@ func (m *ShardMap[K, V]) SetIfNotExists(key K, value V) bool {
 	shard := m.shardsFunc(key)
 	m.shardsLock[shard].Lock()
 	defer m.shardsLock[shard].Unlock()
+	m.evictExpiredLocked(shard, key)
 	if _, ok := m.shards[shard][key]; ok {
 		return false
 	}
 	m.shards[shard][key] = value
+	if m.watch.watching() {
+		m.watch.notifySet(key, value, false, value)
+	}
 	return true
 }
 
@@ -62,22 +101,36 @@ func (m *ShardMap[K, V]) SetIfNotExistsWithFunc(key K, fn func() V) (V, bool) {
 	shard := m.shardsFunc(key)
 	m.shardsLock[shard].Lock()
 	defer m.shardsLock[shard].Unlock()
+	m.evictExpiredLocked(shard, key)
 	if v, ok := m.shards[shard][key]; ok {
 		return v, false
 	}
 	v := fn()
 	m.shards[shard][key] = v
+	if m.watch.watching() {
+		m.watch.notifySet(key, v, false, v)
+	}
 	return v, true
 }
 
 func (m *ShardMap[K, V]) Clear() {
 	for i := 0; i < m.shardsCount; i++ {
 		m.shardsLock[i].Lock()
+		if m.watch.watching() {
+			now := m.clock.Now()
+			for k, v := range m.shards[i] {
+				if !m.expiredLocked(i, k, now) {
+					m.watch.notifyDelete(k, v)
+				}
+			}
+		}
 		m.shards[i] = make(map[K]V)
+		m.expiries[i] = nil
 		m.shardsLock[i].Unlock()
 	}
 }
 
+// Size returns the number of entries, including the expired entries not yet removed by the janitor
 func (m *ShardMap[K, V]) Size() int {
 	count := 0
 	for i := 0; i < m.shardsCount; i++ {
@@ -88,37 +141,207 @@ func (m *ShardMap[K, V]) Size() int {
 	return count
 }
 
+// Range calls fn for the entries of every shard, each shard is copied under its lock, so fn may call the map
+func (m *ShardMap[K, V]) Range(fn func(key K, value V) bool) {
+	var keys []K
+	var values []V
+	for i := 0; i < m.shardsCount; i++ {
+		keys, values = keys[:0], values[:0]
+		m.shardsLock[i].RLock()
+		now := m.clock.Now()
+		for k, v := range m.shards[i] {
+			if m.expiredLocked(i, k, now) {
+				continue
+			}
+			keys = append(keys, k)
+			values = append(values, v)
+		}
+		m.shardsLock[i].RUnlock()
+		for j := range keys {
+			if !fn(keys[j], values[j]) {
+				return
+			}
+		}
+	}
+}
+
+func (m *ShardMap[K, V]) Keys() []K {
+	keys := make([]K, 0, m.Size())
+	for i := 0; i < m.shardsCount; i++ {
+		m.shardsLock[i].RLock()
+		now := m.clock.Now()
+		for k := range m.shards[i] {
+			if m.expiredLocked(i, k, now) {
+				continue
+			}
+			keys = append(keys, k)
+		}
+		m.shardsLock[i].RUnlock()
+	}
+	return keys
+}
+
+func (m *ShardMap[K, V]) Values() []V {
+	values := make([]V, 0, m.Size())
+	for i := 0; i < m.shardsCount; i++ {
+		m.shardsLock[i].RLock()
+		now := m.clock.Now()
+		for k, v := range m.shards[i] {
+			if m.expiredLocked(i, k, now) {
+				continue
+			}
+			values = append(values, v)
+		}
+		m.shardsLock[i].RUnlock()
+	}
+	return values
+}
+
+func (m *ShardMap[K, V]) Compute(key K, fn func(old V, exists bool) (V, ComputeAction)) (V, bool) {
+	shard := m.shardsFunc(key)
+	m.shardsLock[shard].Lock()
+	defer m.shardsLock[shard].Unlock()
+	m.evictExpiredLocked(shard, key)
+	v, ok := computeWatched(&m.watch, m.shards[shard], key, fn)
+	if !ok {
+		m.dropDeadlineLocked(shard, key)
+	}
+	return v, ok
+}
+
+func (m *ShardMap[K, V]) GetAndDelete(key K) (V, bool) {
+	shard := m.shardsFunc(key)
+	m.shardsLock[shard].Lock()
+	defer m.shardsLock[shard].Unlock()
+	m.evictExpiredLocked(shard, key)
+	v, ok := m.shards[shard][key]
+	delete(m.shards[shard], key)
+	m.dropDeadlineLocked(shard, key)
+	if ok && m.watch.watching() {
+		m.watch.notifyDelete(key, v)
+	}
+	return v, ok
+}
+
+func (m *ShardMap[K, V]) CompareAndSwap(key K, old, new V, eq func(a, b V) bool) bool {
+	shard := m.shardsFunc(key)
+	m.shardsLock[shard].Lock()
+	defer m.shardsLock[shard].Unlock()
+	m.evictExpiredLocked(shard, key)
+	cur := m.shards[shard][key]
+	if !compareAndSwapEntry(m.shards[shard], key, old, new, eq) {
+		return false
+	}
+	if m.watch.watching() {
+		m.watch.notifySet(key, cur, true, new)
+	}
+	return true
+}
+
+func (m *ShardMap[K, V]) All() iter.Seq2[K, V] {
+	return m.Range
+}
+
+// Watch returns the events of all keys, the removal of an expired entry is sent as EventDelete
+func (m *ShardMap[K, V]) Watch(ctx context.Context, opts ...WatchOption) <-chan Event[K, V] {
+	return m.watch.watch(ctx, opts)
+}
+
+func (m *ShardMap[K, V]) WatchKey(ctx context.Context, key K, opts ...WatchOption) <-chan Event[K, V] {
+	return m.watch.watchKey(ctx, key, opts)
+}
+
 type ShardMapBuilder[K comparable, V any] struct {
-	shardsCount int
-	shardsFunc  func(K) int
+	shardsCount     int
+	shardsFunc      func(K) int
+	ctx             context.Context
+	clock           Clock
+	onEvict         func(key K, value V)
+	janitorInterval time.Duration
 }
 
 func NewShardMap[K comparable, V any]() *ShardMapBuilder[K, V] {
 	return &ShardMapBuilder[K, V]{
-		shardsCount: 6,
+		shardsCount:     6,
+		ctx:             context.Background(),
+		clock:           systemClock{},
+		janitorInterval: time.Second,
 	}
 }
 
+// WithContext sets the context that stops the janitor removing the entries set with ttl
+func (b *ShardMapBuilder[K, V]) WithContext(ctx context.Context) *ShardMapBuilder[K, V] {
+	b.ctx = ctx
+	return b
+}
+
+// WithClock sets the clock used for the entry expiry, mostly for tests
+func (b *ShardMapBuilder[K, V]) WithClock(c Clock) *ShardMapBuilder[K, V] {
+	b.clock = c
+	return b
+}
+
+// WithEvictionFunc sets the func called when an expired entry is removed.
+// It is called under the shard lock, so it must be fast and must not call the map
+func (b *ShardMapBuilder[K, V]) WithEvictionFunc(fn func(key K, value V)) *ShardMapBuilder[K, V] {
+	b.onEvict = fn
+	return b
+}
+
+// WithJanitorInterval sets how often the janitor removes the expired entries, 1s by default
+func (b *ShardMapBuilder[K, V]) WithJanitorInterval(d time.Duration) *ShardMapBuilder[K, V] {
+	b.janitorInterval = d
+	return b
+}
+
 func (b *ShardMapBuilder[K, V]) WithShardsCount(shardsCount int) *ShardMapBuilder[K, V] {
 	b.shardsCount = shardsCount
 	return b
 }
 
+// WithShardsFunc sets the func choosing the shard of the key, its result is taken modulo the shards count.
+// By default the keys are spread by their hash
 func (b *ShardMapBuilder[K, V]) WithShardsFunc(shardsFunc func(K) int) *ShardMapBuilder[K, V] {
 	b.shardsFunc = shardsFunc
 	return b
 }
 
 func (b *ShardMapBuilder[K, V]) Build() *ShardMap[K, V] {
-	//если K - numeric то shardsFunc можно назначить автоматом
+	if b.shardsCount < 1 {
+		b.shardsCount = 1
+	}
+	if b.janitorInterval <= 0 {
+		b.janitorInterval = time.Second
+	}
 	m := &ShardMap[K, V]{
-		shards:      make([]map[K]V, b.shardsCount),
-		shardsLock:  make([]sync.RWMutex, b.shardsCount),
-		shardsFunc:  b.shardsFunc,
-		shardsCount: b.shardsCount,
+		shards:          make([]map[K]V, b.shardsCount),
+		shardsLock:      make([]sync.RWMutex, b.shardsCount),
+		shardsFunc:      normalizeShardsFunc(b.shardsFunc, b.shardsCount),
+		shardsCount:     b.shardsCount,
+		expiries:        make([]*shardExpiry[K], b.shardsCount),
+		onEvict:         b.onEvict,
+		clock:           b.clock,
+		ctx:             b.ctx,
+		janitorInterval: b.janitorInterval,
 	}
 	for i := 0; i < b.shardsCount; i++ {
 		m.shards[i] = make(map[K]V)
 	}
 	return m
 }
+
+// normalizeShardsFunc keeps the result of the custom func in range, or returns the hash based func if there is none
+func normalizeShardsFunc[K comparable](f func(K) int, count int) func(K) int {
+	if f == nil {
+		hash := newKeyHasher[K]()
+		n := uint64(count)
+		return func(k K) int { return int(hash(k) % n) }
+	}
+	return func(k K) int {
+		shard := f(k) % count
+		if shard < 0 {
+			shard += count
+		}
+		return shard
+	}
+}
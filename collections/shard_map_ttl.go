@@ -0,0 +1,168 @@
+package collections
+
+import (
+	"container/heap"
+	"time"
+)
+
+/*
+ __    _           ___
+|  |  |_|_____ ___|_  |
+|  |__| |     | .'|  _|
+|_____|_|_|_|_|__,|___|
+zed (11.04.2024)
+*/
+
+// shardExpiry keeps the deadlines of the entries of a shard set with ttl, it is allocated by the first SetWithTTL
+// of the shard. Every key has a single item in the queue, a renewal moves it
+type shardExpiry[K comparable] struct {
+	deadlines map[K]*expiryItem[K]
+	queue     expiryQueue[K]
+}
+
+type expiryItem[K comparable] struct {
+	key   K
+	at    time.Time
+	index int
+}
+
+// SetWithTTL sets the value that is removed after ttl. Compute and CompareAndSwap keep the ttl of the entry,
+// Set and SetIfNotExists replace it with an entry without ttl.
+// Expired entries are not returned, they are removed by the janitor started by the first call
+func (m *ShardMap[K, V]) SetWithTTL(key K, value V, ttl time.Duration) {
+	shard := m.shardsFunc(key)
+	m.shardsLock[shard].Lock()
+	defer m.shardsLock[shard].Unlock()
+	e := m.expiries[shard]
+	if e == nil {
+		e = &shardExpiry[K]{deadlines: make(map[K]*expiryItem[K])}
+		m.expiries[shard] = e
+	}
+	at := m.clock.Now().Add(ttl)
+	if m.watch.watching() {
+		old, existed := m.lookupLocked(shard, key)
+		defer m.watch.notifySet(key, old, existed, value)
+	}
+	m.shards[shard][key] = value
+	if item, ok := e.deadlines[key]; ok {
+		item.at = at
+		heap.Fix(&e.queue, item.index)
+	} else {
+		item = &expiryItem[K]{key: key, at: at}
+		e.deadlines[key] = item
+		heap.Push(&e.queue, item)
+	}
+	m.janitorOnce.Do(func() { go m.janitor() })
+}
+
+// expiredLocked reports if the entry of key has a deadline that is passed
+func (m *ShardMap[K, V]) expiredLocked(shard int, key K, now time.Time) bool {
+	e := m.expiries[shard]
+	if e == nil {
+		return false
+	}
+	item, ok := e.deadlines[key]
+	return ok && !now.Before(item.at)
+}
+
+// evictExpiredLocked removes the entry of key if it is expired, must be called under the write lock of the shard
+func (m *ShardMap[K, V]) evictExpiredLocked(shard int, key K) {
+	if m.expiries[shard] != nil && m.expiredLocked(shard, key, m.clock.Now()) {
+		m.evictLocked(shard, key)
+	}
+}
+
+func (m *ShardMap[K, V]) evictLocked(shard int, key K) {
+	v := m.shards[shard][key]
+	delete(m.shards[shard], key)
+	m.dropDeadlineLocked(shard, key)
+	if m.onEvict != nil {
+		m.onEvict(key, v)
+	}
+	if m.watch.watching() {
+		m.watch.notifyDelete(key, v)
+	}
+}
+
+// lookupLocked returns the value of key if it is not expired
+func (m *ShardMap[K, V]) lookupLocked(shard int, key K) (V, bool) {
+	v, ok := m.shards[shard][key]
+	if ok && m.expiries[shard] != nil && m.expiredLocked(shard, key, m.clock.Now()) {
+		var empty V
+		return empty, false
+	}
+	return v, ok
+}
+
+// dropDeadlineLocked removes the ttl of key, the entry is kept
+func (m *ShardMap[K, V]) dropDeadlineLocked(shard int, key K) {
+	if e := m.expiries[shard]; e != nil {
+		if item, ok := e.deadlines[key]; ok {
+			heap.Remove(&e.queue, item.index)
+			delete(e.deadlines, key)
+		}
+	}
+}
+
+func (m *ShardMap[K, V]) janitor() {
+	ticker := time.NewTicker(m.janitorInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-m.ctx.Done():
+			return
+		case <-ticker.C:
+			m.sweep()
+		}
+	}
+}
+
+// sweep removes the expired entries of all shards, shards without expired entries are checked under the read lock
+func (m *ShardMap[K, V]) sweep() {
+	for i := 0; i < m.shardsCount; i++ {
+		m.shardsLock[i].RLock()
+		due := m.dueLocked(i, m.clock.Now())
+		m.shardsLock[i].RUnlock()
+		if !due {
+			continue
+		}
+		m.shardsLock[i].Lock()
+		now := m.clock.Now()
+		for m.dueLocked(i, now) {
+			m.evictLocked(i, m.expiries[i].queue[0].key)
+		}
+		m.shardsLock[i].Unlock()
+	}
+}
+
+// dueLocked reports if the earliest deadline of the shard is passed
+func (m *ShardMap[K, V]) dueLocked(shard int, now time.Time) bool {
+	e := m.expiries[shard]
+	return e != nil && len(e.queue) > 0 && !now.Before(e.queue[0].at)
+}
+
+type expiryQueue[K comparable] []*expiryItem[K]
+
+func (q expiryQueue[K]) Len() int           { return len(q) }
+func (q expiryQueue[K]) Less(i, j int) bool { return q[i].at.Before(q[j].at) }
+
+func (q expiryQueue[K]) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+
+func (q *expiryQueue[K]) Push(x any) {
+	item := x.(*expiryItem[K])
+	item.index = len(*q)
+	*q = append(*q, item)
+}
+
+func (q *expiryQueue[K]) Pop() any {
+	old := *q
+	n := len(old)
+	item := old[n-1]
+	old[n-1] = nil
+	*q = old[:n-1]
+	return item
+}
@@ -0,0 +1,108 @@
+package collections
+
+import (
+	"github.com/go-errors/errors"
+	"sort"
+)
+
+/*
+ __    _           ___
+|  |  |_|_____ ___|_  |
+|  |__| |     | .'|  _|
+|_____|_|_|_|_|__,|___|
+zed (11.04.2024)
+*/
+
+var ErrTxKeyNotDeclared = errors.New("key is not declared in transaction")
+
+// Tx reads and writes the keys declared in ShardMap.Atomically.
+// Writes are buffered and applied when the callback returns nil, Tx must not be used after the callback returns
+type Tx[K comparable, V any] interface {
+	// Get returns the value of key including the writes of the transaction, a key not passed to Atomically is not found
+	Get(key K) (V, bool)
+	// Set returns ErrTxKeyNotDeclared for a key not passed to Atomically
+	Set(key K, value V) error
+	// Delete returns ErrTxKeyNotDeclared for a key not passed to Atomically
+	Delete(key K) error
+}
+
+type shardTxWrite[V any] struct {
+	value   V
+	deleted bool
+}
+
+type shardTx[K comparable, V any] struct {
+	m      *ShardMap[K, V]
+	shards map[K]int
+	writes map[K]shardTxWrite[V]
+	order  []K
+}
+
+// Atomically locks the shards of keys and calls fn with a Tx over them. The writes of fn are applied together
+// if fn returns nil and discarded otherwise, the error of fn is returned.
+// The shards are locked in ascending order, so concurrent calls with overlapping keys don't deadlock.
+// fn must not call the map
+func (m *ShardMap[K, V]) Atomically(keys []K, fn func(tx Tx[K, V]) error) error {
+	tx := &shardTx[K, V]{
+		m:      m,
+		shards: make(map[K]int, len(keys)),
+		writes: make(map[K]shardTxWrite[V], len(keys)),
+	}
+	var locked []int
+	for _, key := range keys {
+		shard := m.shardsFunc(key)
+		tx.shards[key] = shard
+		locked = append(locked, shard)
+	}
+	sort.Ints(locked)
+	for i, shard := range locked {
+		if i > 0 && locked[i-1] == shard {
+			continue
+		}
+		m.shardsLock[shard].Lock()
+		defer m.shardsLock[shard].Unlock()
+	}
+	if err := fn(tx); err != nil {
+		return err
+	}
+	for _, key := range tx.order {
+		w := tx.writes[key]
+		if w.deleted {
+			m.deleteLocked(tx.shards[key], key)
+		} else {
+			m.setLocked(tx.shards[key], key, w.value)
+		}
+	}
+	return nil
+}
+
+func (tx *shardTx[K, V]) Get(key K) (V, bool) {
+	if w, ok := tx.writes[key]; ok {
+		return w.value, !w.deleted
+	}
+	shard, ok := tx.shards[key]
+	if !ok {
+		var empty V
+		return empty, false
+	}
+	return tx.m.lookupLocked(shard, key)
+}
+
+func (tx *shardTx[K, V]) Set(key K, value V) error {
+	return tx.write(key, shardTxWrite[V]{value: value})
+}
+
+func (tx *shardTx[K, V]) Delete(key K) error {
+	return tx.write(key, shardTxWrite[V]{deleted: true})
+}
+
+func (tx *shardTx[K, V]) write(key K, w shardTxWrite[V]) error {
+	if _, ok := tx.shards[key]; !ok {
+		return ErrTxKeyNotDeclared
+	}
+	if _, ok := tx.writes[key]; !ok {
+		tx.order = append(tx.order, key)
+	}
+	tx.writes[key] = w
+	return nil
+}
@@ -1,6 +1,9 @@
 package collections
 
-import "sync"
+import (
+	"context"
+	"sync"
+)
 
 /*
  __    _           ___
@@ -15,6 +18,7 @@ type TwoKeyMap[K1, K2 comparable, V any] struct {
 	mu     sync.RWMutex
 	k1Tok2 map[K1]K2
 	k2Tok1 map[K2]K1
+	watch  watchHub[K1, V]
 }
 
 func NewTwoKeyMap[K1, K2 comparable, V any]() *TwoKeyMap[K1, K2, V] {
@@ -28,6 +32,10 @@ func NewTwoKeyMap[K1, K2 comparable, V any]() *TwoKeyMap[K1, K2, V] {
 func (m *TwoKeyMap[K1, K2, V]) Put(k1 K1, k2 K2, v V) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
+	if m.watch.watching() {
+		old, existed := m.data[k1]
+		defer m.watch.notifySet(k1, old, existed, v)
+	}
 	m.data[k1] = v
 	m.k1Tok2[k1] = k2
 	m.k2Tok1[k2] = k1
@@ -55,6 +63,7 @@ func (m *TwoKeyMap[K1, K2, V]) GetByK2(k2 K2) (v V, ok bool) {
 func (m *TwoKeyMap[K1, K2, V]) Remove(k1 K1) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
+	m.notifyRemoveLocked(k1)
 	delete(m.data, k1)
 	delete(m.k2Tok1, m.k1Tok2[k1])
 	delete(m.k1Tok2, k1)
@@ -63,6 +72,9 @@ func (m *TwoKeyMap[K1, K2, V]) Remove(k1 K1) {
 func (m *TwoKeyMap[K1, K2, V]) RemoveByK2(k2 K2) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
+	if k1, ok := m.k2Tok1[k2]; ok {
+		m.notifyRemoveLocked(k1)
+	}
 	delete(m.data, m.k2Tok1[k2])
 	delete(m.k1Tok2, m.k2Tok1[k2])
 	delete(m.k2Tok1, k2)
@@ -93,3 +105,21 @@ func (m *TwoKeyMap[K1, K2, V]) KeysK2() []K2 {
 	}
 	return keys
 }
+
+// Watch returns the events of all entries by K1, removing by K2 is sent as EventDelete of its K1
+func (m *TwoKeyMap[K1, K2, V]) Watch(ctx context.Context, opts ...WatchOption) <-chan Event[K1, V] {
+	return m.watch.watch(ctx, opts)
+}
+
+func (m *TwoKeyMap[K1, K2, V]) WatchKey(ctx context.Context, k1 K1, opts ...WatchOption) <-chan Event[K1, V] {
+	return m.watch.watchKey(ctx, k1, opts)
+}
+
+func (m *TwoKeyMap[K1, K2, V]) notifyRemoveLocked(k1 K1) {
+	if !m.watch.watching() {
+		return
+	}
+	if old, ok := m.data[k1]; ok {
+		m.watch.notifyDelete(k1, old)
+	}
+}
@@ -16,12 +16,35 @@ zed (03.11.2024)
 */
 
 type WaitMap[K comparable, V any] struct {
-	waiterChannels map[K][]chan V
-	dataHolder     map[K]V
+	waiterChannels map[K][]chan waitResult[V]
+	dataHolder     map[K]waitResult[V]
 	mu             sync.RWMutex
 	requestTimeout time.Duration
 	responseTtl    time.Duration
 	ctx            context.Context
+	scheduler      *Scheduler
+	lateResponses  bool
+	// timeout tasks of the waited keys and ttl tasks of the stored results
+	timeouts map[K]waitingKey
+	ttls     map[K]*ScheduledTask
+	// expired keeps the timed out keys for responseTtl to drop their late responses, unless late responses are accepted.
+	// A new Wait of the key removes it
+	expired  map[K]*ScheduledTask
+	observer *waitObserver[K]
+}
+
+type waitingKey struct {
+	task      *ScheduledTask
+	createdAt time.Time
+}
+
+type waitResult[V any] struct {
+	value V
+	err   error
+}
+
+func (wm *WaitMap[K, V]) Stats() WaitStats {
+	return wm.observer.stats()
 }
 
 func (wm *WaitMap[K, V]) Count() int {
@@ -29,53 +52,85 @@ func (wm *WaitMap[K, V]) Count() int {
 	defer wm.mu.RUnlock()
 	a := len(wm.waiterChannels)
 	b := len(wm.dataHolder)
-	return a + b
+	c := len(wm.expired)
+	return a + b + c
+}
+
+// Wait returns the value of key, ErrTimeout when the request timeout is exceeded or ErrCancelled when the map context is done
+func (wm *WaitMap[K, V]) Wait(key K) (V, error) {
+	return wm.WaitCtx(context.Background(), key)
 }
 
-func (wm *WaitMap[K, V]) Wait(key K) V {
+// WaitCtx works as Wait, but also stops waiting when ctx is done without affecting other waiters of key.
+// Returns ErrTimeout when the request timeout or ctx deadline is exceeded, ErrCancelled when ctx or the map context is cancelled
+func (wm *WaitMap[K, V]) WaitCtx(ctx context.Context, key K) (V, error) {
 	wm.mu.RLock()
-	value, ok := wm.dataHolder[key]
+	res, ok := wm.dataHolder[key]
 	wm.mu.RUnlock()
 	if ok {
-		return value
+		return res.value, res.err
 	}
 
 	wm.mu.Lock()
-	value, ok = wm.dataHolder[key]
+	res, ok = wm.dataHolder[key]
 	if ok {
 		wm.mu.Unlock()
-		return value
-	}
-
-	ch := make(chan V, 1)
-	_, ok = wm.waiterChannels[key]
-	if !ok {
-		go func() {
-			select {
-			case <-wm.ctx.Done():
-				wm.mu.Lock()
-				if chans, exists := wm.waiterChannels[key]; exists {
-					var v V
-					for _, ch := range chans {
-						ch <- v
-						close(ch)
-					}
-					delete(wm.waiterChannels, key)
-				}
-				wm.mu.Unlock()
-			case <-time.After(wm.requestTimeout): // TIMEOUT
-				var v V
-				wm.Set(key, v)
-			}
-		}()
+		return res.value, res.err
+	}
+
+	var v V
+	if wm.ctx.Err() != nil {
+		wm.mu.Unlock()
+		return v, ErrCancelled
+	}
+	if t, ok := wm.expired[key]; ok {
+		// a new request of the timed out key, the next value is its response and not the late one
+		t.Cancel()
+		delete(wm.expired, key)
+	}
+	ch := make(chan waitResult[V], 1)
+	if _, ok = wm.timeouts[key]; !ok {
+		wm.timeouts[key] = waitingKey{
+			task:      wm.scheduler.Schedule(wm.requestTimeout, func() { wm.timeout(key) }),
+			createdAt: time.Now(),
+		}
+		wm.observer.onCreated(key)
 	}
 	wm.waiterChannels[key] = append(wm.waiterChannels[key], ch)
 	wm.mu.Unlock()
 
-	value = <-ch
-	return value
+	wm.observer.pending.Add(1)
+	defer wm.observer.pending.Add(-1)
+
+	select {
+	case res = <-ch:
+		return res.value, res.err
+	case <-ctx.Done():
+		wm.mu.Lock()
+		defer wm.mu.Unlock()
+		select {
+		case res = <-ch: // already delivered
+			return res.value, res.err
+		default:
+		}
+		chans := wm.waiterChannels[key]
+		for i, c := range chans {
+			if c == ch {
+				chans = append(chans[:i], chans[i+1:]...)
+				break
+			}
+		}
+		if len(chans) == 0 {
+			delete(wm.waiterChannels, key)
+		} else {
+			wm.waiterChannels[key] = chans
+		}
+		return v, ctxError(ctx, ErrTimeout, ErrCancelled)
+	}
 }
 
+// Set stores the value of key for responseTtl and releases the waiters. The first value wins,
+// a value set after the request timeout and before the next Wait of the key is ignored unless the map is built WithLateResponses
 func (wm *WaitMap[K, V]) Set(key K, value V) {
 	wm.mu.Lock()
 	defer wm.mu.Unlock()
@@ -83,35 +138,97 @@ func (wm *WaitMap[K, V]) Set(key K, value V) {
 	if _, ok := wm.dataHolder[key]; ok {
 		return
 	}
+	if _, ok := wm.expired[key]; ok {
+		return
+	}
+	if w, ok := wm.timeouts[key]; ok {
+		w.task.Cancel()
+		delete(wm.timeouts, key)
+		wm.observer.onResolved(key, w.createdAt)
+	} else {
+		wm.observer.onUnsolicited()
+	}
+	res := waitResult[V]{value: value}
+	wm.release(key, res)
+	if wm.ctx.Err() != nil {
+		return
+	}
+
+	wm.dataHolder[key] = res
+	wm.ttls[key] = wm.scheduler.Schedule(wm.responseTtl, func() {
+		wm.mu.Lock()
+		delete(wm.dataHolder, key)
+		delete(wm.ttls, key)
+		wm.mu.Unlock()
+		wm.observer.onExpired(key)
+	})
+}
 
-	wm.dataHolder[key] = value
+// timeout releases the waiters of key with ErrTimeout, the key is kept as expired for responseTtl
+// or until the next Wait of the key
+func (wm *WaitMap[K, V]) timeout(key K) {
+	wm.mu.Lock()
+	defer wm.mu.Unlock()
+	if _, ok := wm.timeouts[key]; !ok { // already set
+		return
+	}
+	delete(wm.timeouts, key)
+	wm.observer.onTimeout(key)
+	wm.release(key, waitResult[V]{err: ErrTimeout})
+	if wm.lateResponses || wm.ctx.Err() != nil {
+		return
+	}
+	wm.expired[key] = wm.scheduler.Schedule(wm.responseTtl, func() {
+		wm.mu.Lock()
+		delete(wm.expired, key)
+		wm.mu.Unlock()
+		wm.observer.onExpired(key)
+	})
+}
+
+func (wm *WaitMap[K, V]) release(key K, res waitResult[V]) {
 	if chans, ok := wm.waiterChannels[key]; ok {
 		for _, ch := range chans {
-			ch <- value
+			ch <- res
 			close(ch) // Закрываем канал после отправки значения
 		}
 		delete(wm.waiterChannels, key)
 	}
+}
 
-	go func() {
-		select {
-		case <-wm.ctx.Done():
-			wm.mu.Lock()
-			delete(wm.dataHolder, key)
-			wm.mu.Unlock()
-			return
-		case <-time.After(wm.responseTtl):
-			wm.mu.Lock()
-			delete(wm.dataHolder, key)
-			wm.mu.Unlock()
+// cancel releases all waiters with ErrCancelled and drops the results when the map context is done
+func (wm *WaitMap[K, V]) cancel() {
+	wm.mu.Lock()
+	defer wm.mu.Unlock()
+	for key, chans := range wm.waiterChannels {
+		for _, ch := range chans {
+			ch <- waitResult[V]{err: ErrCancelled}
+			close(ch)
 		}
-	}()
+		delete(wm.waiterChannels, key)
+	}
+	for key, w := range wm.timeouts {
+		w.task.Cancel()
+		delete(wm.timeouts, key)
+	}
+	for key, t := range wm.ttls {
+		t.Cancel()
+		delete(wm.ttls, key)
+	}
+	for key, t := range wm.expired {
+		t.Cancel()
+		delete(wm.expired, key)
+	}
+	clear(wm.dataHolder)
 }
 
 type WaitMapBuilder[K comparable, V any] struct {
 	requestTimeout time.Duration
 	responseTtl    time.Duration
 	ctx            context.Context
+	scheduler      *Scheduler
+	lateResponses  bool
+	hooks          WaitHooks[K]
 }
 
 func NewWaitMap[K comparable, V any]() *WaitMapBuilder[K, V] {
@@ -137,13 +254,42 @@ func (b *WaitMapBuilder[K, V]) WithContext(ctx context.Context) *WaitMapBuilder[
 	return b
 }
 
+// WithLateResponses accepts a value set after the request timeout, it is returned to the next waiters.
+// By default the late value is ignored, unless the key is waited again after the timeout
+func (b *WaitMapBuilder[K, V]) WithLateResponses() *WaitMapBuilder[K, V] {
+	b.lateResponses = true
+	return b
+}
+
+func (b *WaitMapBuilder[K, V]) WithHooks(hooks WaitHooks[K]) *WaitMapBuilder[K, V] {
+	b.hooks = hooks
+	return b
+}
+
+// WithScheduler sets the scheduler of the request timeouts and response ttl, DefaultScheduler by default
+func (b *WaitMapBuilder[K, V]) WithScheduler(s *Scheduler) *WaitMapBuilder[K, V] {
+	b.scheduler = s
+	return b
+}
+
 func (b *WaitMapBuilder[K, V]) Build() *WaitMap[K, V] {
-	return &WaitMap[K, V]{
-		waiterChannels: make(map[K][]chan V),
-		dataHolder:     make(map[K]V),
+	if b.scheduler == nil {
+		b.scheduler = DefaultScheduler()
+	}
+	wm := &WaitMap[K, V]{
+		waiterChannels: make(map[K][]chan waitResult[V]),
+		dataHolder:     make(map[K]waitResult[V]),
 		mu:             sync.RWMutex{},
 		requestTimeout: b.requestTimeout,
 		responseTtl:    b.responseTtl,
 		ctx:            b.ctx,
+		scheduler:      b.scheduler,
+		lateResponses:  b.lateResponses,
+		timeouts:       make(map[K]waitingKey),
+		ttls:           make(map[K]*ScheduledTask),
+		expired:        make(map[K]*ScheduledTask),
+		observer:       newWaitObserver(b.hooks),
 	}
+	context.AfterFunc(b.ctx, wm.cancel)
+	return wm
 }
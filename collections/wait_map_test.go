@@ -1,6 +1,8 @@
 package collections
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"github.com/stretchr/testify/assert"
 	"math/rand/v2"
@@ -44,9 +46,13 @@ func TestWaitMap_Set(t *testing.T) {
 	}
 	wm := NewWaitMap[int, *demo]().Build()
 	wm.Set(1, &demo{name: "demo"})
-	assert.Equal(t, "demo", wm.Wait(1).name)
+	v, err := wm.Wait(1)
+	assert.Nil(t, err)
+	assert.Equal(t, "demo", v.name)
 	wm.Set(1, &demo{name: "demo-bad"})
-	assert.Equal(t, "demo", wm.Wait(1).name)
+	v, err = wm.Wait(1)
+	assert.Nil(t, err)
+	assert.Equal(t, "demo", v.name)
 }
 
 func TestWaitMap_WaitSet(t *testing.T) {
@@ -63,23 +69,34 @@ func TestWaitMap_WaitSet(t *testing.T) {
 		time.Sleep(time.Millisecond * 100)
 		wm.Set(2, &demo{name: "demo-w2"})
 	}()
-	w1 := wm.Wait(1)
+	w1, err := wm.Wait(1)
+	assert.Nil(t, err)
 	assert.Equal(t, "demo", w1.name)
 	assert.Equal(t, 1, wm.Count())
-	w2 := wm.Wait(2)
+	w2, err := wm.Wait(2)
+	assert.True(t, errors.Is(err, ErrTimeout))
 	assert.Equal(t, 2, wm.Count())
 
 	assert.NotNil(t, w1)
 	assert.Equal(t, "demo", w1.name)
 	assert.Nil(t, w2)
 	time.Sleep(time.Millisecond * 100)
-	w1 = wm.Wait(1)
-	w2 = wm.Wait(2)
-	assert.Equal(t, 2, wm.Count())
+	w1, _ = wm.Wait(1)
 	assert.NotNil(t, w1)
 	assert.Equal(t, "demo", w1.name)
-	assert.Nil(t, w2)
-	time.Sleep(time.Millisecond * 200)
+	// the late response is ignored, the timed out key is kept until it is waited again
+	assert.Equal(t, 2, wm.Count())
+
+	// a new wait of the timed out key receives the next response
+	go func() {
+		time.Sleep(time.Millisecond * 10)
+		wm.Set(2, &demo{name: "demo-w2-retry"})
+	}()
+	w2, err = wm.Wait(2)
+	assert.Nil(t, err)
+	assert.Equal(t, "demo-w2-retry", w2.name)
+	assert.Equal(t, 2, wm.Count())
+	time.Sleep(time.Millisecond * 400)
 	assert.Equal(t, 0, wm.Count())
 }
 
@@ -109,3 +126,96 @@ func TestWaitMap_MemoryLeak(t *testing.T) {
 	t.Log("delta goroutine", runtime.NumGoroutine()-gor)
 	assert.Less(t, runtime.NumGoroutine(), gor+1)
 }
+
+func TestWaitMap_WaitCtx(t *testing.T) {
+	wm := NewWaitMap[int, string]().WithRequestTimeout(time.Millisecond * 50).Build()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*10)
+	defer cancel()
+	done := make(chan string, 1)
+	go func() {
+		v, err := wm.WaitCtx(context.Background(), 1)
+		assert.Nil(t, err)
+		done <- v
+	}()
+	_, err := wm.WaitCtx(ctx, 1)
+	assert.True(t, errors.Is(err, ErrTimeout))
+	wm.Set(1, "value")
+	assert.Equal(t, "value", <-done)
+
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	_, err = wm.WaitCtx(ctx, 2)
+	assert.True(t, errors.Is(err, ErrCancelled))
+	// only the value of key 1 is left, the key 2 has no waiters
+	assert.Equal(t, 1, wm.Count())
+	_, err = wm.WaitCtx(context.Background(), 2)
+	assert.True(t, errors.Is(err, ErrTimeout))
+}
+
+func TestWaitMap_LateResponses(t *testing.T) {
+	wm := NewWaitMap[int, int]().WithRequestTimeout(time.Millisecond * 20).WithLateResponses().Build()
+	_, err := wm.Wait(1)
+	assert.True(t, errors.Is(err, ErrTimeout))
+	assert.Equal(t, 0, wm.Count())
+	wm.Set(1, 0)
+	v, err := wm.Wait(1)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, v)
+
+	// a waiter after the timeout waits for the late response
+	_, err = wm.Wait(2)
+	assert.True(t, errors.Is(err, ErrTimeout))
+	go func() {
+		time.Sleep(time.Millisecond * 5)
+		wm.Set(2, 2)
+	}()
+	v, err = wm.Wait(2)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, v)
+}
+
+// BenchmarkWaitMap_Goroutines waits and sets distinct keys, the goroutine count doesn't depend on the number of keys
+func BenchmarkWaitMap_Goroutines(b *testing.B) {
+	wm := NewWaitMap[int, int]().WithRequestTimeout(time.Second).WithResponseTtl(time.Minute).Build()
+	before := runtime.NumGoroutine()
+	var wg sync.WaitGroup
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		wg.Add(1)
+		go func(key int) {
+			defer wg.Done()
+			wm.Wait(key)
+		}(i)
+		wm.Set(i, i)
+	}
+	wg.Wait()
+	b.StopTimer()
+	b.ReportMetric(float64(wm.Count()), "keys")
+	b.ReportMetric(float64(runtime.NumGoroutine()-before), "goroutines")
+}
+
+func TestWaitMap_Stats(t *testing.T) {
+	var timedOut []int
+	wm := NewWaitMap[int, int]().WithRequestTimeout(time.Millisecond * 20).WithResponseTtl(time.Millisecond * 20).
+		WithHooks(WaitHooks[int]{OnTimeout: func(key int) { timedOut = append(timedOut, key) }}).Build()
+	go func() {
+		time.Sleep(time.Millisecond * 5)
+		assert.Equal(t, int64(1), wm.Stats().Pending)
+		wm.Set(1, 1)
+	}()
+	_, _ = wm.Wait(1)
+	_, _ = wm.Wait(2)
+	// nobody waits for key 3, it is not a resolved request
+	wm.Set(3, 3)
+	time.Sleep(time.Millisecond * 40)
+	stats := wm.Stats()
+	assert.Equal(t, int64(0), stats.Pending)
+	assert.Equal(t, uint64(2), stats.Created)
+	assert.Equal(t, uint64(1), stats.Resolved)
+	assert.Equal(t, uint64(1), stats.TimedOut)
+	assert.Equal(t, uint64(1), stats.Unsolicited)
+	// the results of keys 1 and 3 and the timed out key 2
+	assert.Equal(t, uint64(3), stats.Expired)
+	assert.GreaterOrEqual(t, stats.AverageLatency(), time.Millisecond*5)
+	assert.Equal(t, []int{2}, timedOut)
+}
@@ -0,0 +1,110 @@
+package collections
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+/*
+   ________  ________   _______   ______    ______   _______    _______
+  /        \/        \//       \//      \ //      \ /       \\//       \
+ /        _/        _//        //       ///       //        ///        /
+/-        //       //        _/        //        //         /        _/
+\_______// \_____// \________/\________/\________/\___/____/\____/___/
+zed (03.11.2024)
+*/
+
+// WaitHooks are called by HolderMap, WaitMap and ResponseMap on the entry lifecycle events.
+// Hooks are called synchronously, they must be fast and must not call the map
+type WaitHooks[K comparable] struct {
+	// OnCreated is called when the entry of key is created by the first waiter or the result
+	OnCreated func(key K)
+	// OnResolved is called when the result of key is set, latency is the time since the entry was created
+	OnResolved func(key K, latency time.Duration)
+	// OnTimeout is called when the entry of key is not resolved in time
+	OnTimeout func(key K)
+	// OnExpired is called when the entry of key is removed after its ttl
+	OnExpired func(key K)
+}
+
+// WaitStats is a point-in-time snapshot of the map counters
+type WaitStats struct {
+	// Pending is the number of blocked waiters
+	Pending  int64
+	Created  uint64
+	Resolved uint64
+	TimedOut uint64
+	Expired  uint64
+	// Unsolicited is the number of results set while nobody waited for them, they don't affect the latency
+	Unsolicited  uint64
+	TotalLatency time.Duration
+}
+
+// AverageLatency returns the average time between creating and resolving an entry
+func (s WaitStats) AverageLatency() time.Duration {
+	if s.Resolved == 0 {
+		return 0
+	}
+	return s.TotalLatency / time.Duration(s.Resolved)
+}
+
+type waitObserver[K comparable] struct {
+	hooks        WaitHooks[K]
+	pending      atomic.Int64
+	created      atomic.Uint64
+	resolved     atomic.Uint64
+	timedOut     atomic.Uint64
+	expired      atomic.Uint64
+	unsolicited  atomic.Uint64
+	totalLatency atomic.Int64
+}
+
+func newWaitObserver[K comparable](hooks WaitHooks[K]) *waitObserver[K] {
+	return &waitObserver[K]{hooks: hooks}
+}
+
+func (o *waitObserver[K]) onCreated(key K) {
+	o.created.Add(1)
+	if o.hooks.OnCreated != nil {
+		o.hooks.OnCreated(key)
+	}
+}
+
+func (o *waitObserver[K]) onResolved(key K, createdAt time.Time) {
+	latency := time.Since(createdAt)
+	o.resolved.Add(1)
+	o.totalLatency.Add(int64(latency))
+	if o.hooks.OnResolved != nil {
+		o.hooks.OnResolved(key, latency)
+	}
+}
+
+func (o *waitObserver[K]) onTimeout(key K) {
+	o.timedOut.Add(1)
+	if o.hooks.OnTimeout != nil {
+		o.hooks.OnTimeout(key)
+	}
+}
+
+func (o *waitObserver[K]) onExpired(key K) {
+	o.expired.Add(1)
+	if o.hooks.OnExpired != nil {
+		o.hooks.OnExpired(key)
+	}
+}
+
+func (o *waitObserver[K]) onUnsolicited() {
+	o.unsolicited.Add(1)
+}
+
+func (o *waitObserver[K]) stats() WaitStats {
+	return WaitStats{
+		Pending:      o.pending.Load(),
+		Created:      o.created.Load(),
+		Resolved:     o.resolved.Load(),
+		TimedOut:     o.timedOut.Load(),
+		Expired:      o.expired.Load(),
+		Unsolicited:  o.unsolicited.Load(),
+		TotalLatency: time.Duration(o.totalLatency.Load()),
+	}
+}
@@ -0,0 +1,229 @@
+package collections
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+)
+
+/*
+ __    _           ___
+|  |  |_|_____ ___|_  |
+|  |__| |     | .'|  _|
+|_____|_|_|_|_|__,|___|
+zed (11.04.2024)
+*/
+
+type EventType int
+
+const (
+	// EventPut - the key is added, Old is empty
+	EventPut EventType = iota
+	// EventUpdate - the value of the key is replaced
+	EventUpdate
+	// EventDelete - the key is removed, New is empty
+	EventDelete
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EventPut:
+		return "put"
+	case EventUpdate:
+		return "update"
+	case EventDelete:
+		return "delete"
+	default:
+		return "unknown"
+	}
+}
+
+type Event[K comparable, V any] struct {
+	Type EventType
+	Key  K
+	Old  V
+	New  V
+}
+
+type DropPolicy int
+
+const (
+	// DropOldest - the oldest buffered event is dropped to make room for the new one, the watcher keeps the latest changes
+	DropOldest DropPolicy = iota
+	// DropNewest - the new event is dropped while the buffer is full
+	DropNewest
+)
+
+// Watchable is implemented by SimpleMap, ShardMap and TwoKeyMap.
+// Events are sent without blocking the map, when the buffer of a slow watcher is full they are dropped by its DropPolicy.
+// The channel is closed when ctx is done
+type Watchable[K comparable, V any] interface {
+	// Watch returns the events of all keys
+	Watch(ctx context.Context, opts ...WatchOption) <-chan Event[K, V]
+	// WatchKey returns the events of key
+	WatchKey(ctx context.Context, key K, opts ...WatchOption) <-chan Event[K, V]
+}
+
+type watchOptions struct {
+	buffer int
+	policy DropPolicy
+}
+
+type WatchOption func(o *watchOptions)
+
+// WithWatchBuffer sets the number of events buffered for the watcher, 64 by default
+func WithWatchBuffer(size int) WatchOption {
+	return func(o *watchOptions) {
+		o.buffer = size
+	}
+}
+
+// WithDropPolicy sets which events are dropped when the buffer is full, DropOldest by default
+func WithDropPolicy(policy DropPolicy) WatchOption {
+	return func(o *watchOptions) {
+		o.policy = policy
+	}
+}
+
+type watcher[K comparable, V any] struct {
+	ch     chan Event[K, V]
+	policy DropPolicy
+	mu     sync.Mutex
+}
+
+func (w *watcher[K, V]) send(e Event[K, V]) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	select {
+	case w.ch <- e:
+		return
+	default:
+	}
+	if w.policy == DropNewest {
+		return
+	}
+	select {
+	case <-w.ch:
+	default:
+	}
+	select {
+	case w.ch <- e:
+	default:
+	}
+}
+
+// watchHub keeps the watchers of a map, the zero value is ready to use.
+// The maps call notify under their write lock, so the events of a key are delivered in order
+type watchHub[K comparable, V any] struct {
+	mu     sync.RWMutex
+	all    map[*watcher[K, V]]struct{}
+	byKey  map[K]map[*watcher[K, V]]struct{}
+	active atomic.Int32
+}
+
+// watching reports if there are watchers, the maps skip building the events without them
+func (h *watchHub[K, V]) watching() bool {
+	return h.active.Load() > 0
+}
+
+func (h *watchHub[K, V]) watch(ctx context.Context, opts []WatchOption) <-chan Event[K, V] {
+	w := newWatcher[K, V](opts)
+	h.mu.Lock()
+	if h.all == nil {
+		h.all = make(map[*watcher[K, V]]struct{})
+	}
+	h.all[w] = struct{}{}
+	h.active.Add(1)
+	h.mu.Unlock()
+	context.AfterFunc(ctx, func() {
+		h.mu.Lock()
+		delete(h.all, w)
+		h.active.Add(-1)
+		h.mu.Unlock()
+		close(w.ch)
+	})
+	return w.ch
+}
+
+func (h *watchHub[K, V]) watchKey(ctx context.Context, key K, opts []WatchOption) <-chan Event[K, V] {
+	w := newWatcher[K, V](opts)
+	h.mu.Lock()
+	if h.byKey == nil {
+		h.byKey = make(map[K]map[*watcher[K, V]]struct{})
+	}
+	if h.byKey[key] == nil {
+		h.byKey[key] = make(map[*watcher[K, V]]struct{})
+	}
+	h.byKey[key][w] = struct{}{}
+	h.active.Add(1)
+	h.mu.Unlock()
+	context.AfterFunc(ctx, func() {
+		h.mu.Lock()
+		delete(h.byKey[key], w)
+		if len(h.byKey[key]) == 0 {
+			delete(h.byKey, key)
+		}
+		h.active.Add(-1)
+		h.mu.Unlock()
+		close(w.ch)
+	})
+	return w.ch
+}
+
+func newWatcher[K comparable, V any](opts []WatchOption) *watcher[K, V] {
+	o := watchOptions{buffer: 64, policy: DropOldest}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.buffer < 1 {
+		o.buffer = 1
+	}
+	return &watcher[K, V]{ch: make(chan Event[K, V], o.buffer), policy: o.policy}
+}
+
+func (h *watchHub[K, V]) notify(e Event[K, V]) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for w := range h.all {
+		w.send(e)
+	}
+	for w := range h.byKey[e.Key] {
+		w.send(e)
+	}
+}
+
+// notifySet sends Put or Update depending on whether the key existed before
+func (h *watchHub[K, V]) notifySet(key K, old V, existed bool, value V) {
+	e := Event[K, V]{Type: EventPut, Key: key, New: value}
+	if existed {
+		e.Type, e.Old = EventUpdate, old
+	}
+	h.notify(e)
+}
+
+func (h *watchHub[K, V]) notifyDelete(key K, old V) {
+	h.notify(Event[K, V]{Type: EventDelete, Key: key, Old: old})
+}
+
+// computeWatched calls computeEntry and notifies the watchers about the change made by fn
+func computeWatched[K comparable, V any](h *watchHub[K, V], m map[K]V, key K, fn func(old V, exists bool) (V, ComputeAction)) (V, bool) {
+	if !h.watching() {
+		return computeEntry(m, key, fn)
+	}
+	var old V
+	var existed bool
+	var action ComputeAction
+	v, ok := computeEntry(m, key, func(o V, exists bool) (V, ComputeAction) {
+		old, existed = o, exists
+		var nv V
+		nv, action = fn(o, exists)
+		return nv, action
+	})
+	switch {
+	case action == ComputeSet:
+		h.notifySet(key, old, existed, v)
+	case action == ComputeDelete && existed:
+		h.notifyDelete(key, old)
+	}
+	return v, ok
+}
@@ -0,0 +1,136 @@
+package collections
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+/*
+ __    _           ___
+|  |  |_|_____ ___|_  |
+|  |__| |     | .'|  _|
+|_____|_|_|_|_|__,|___|
+zed (11.04.2024)
+*/
+
+type watchMap interface {
+	Map[string, int]
+	Watchable[string, int]
+}
+
+func TestWatch_Maps(t *testing.T) {
+	maps := map[string]func() watchMap{
+		"simple": func() watchMap { return NewSimpleMap[string, int]() },
+		"shard":  func() watchMap { return NewShardMap[string, int]().Build() },
+	}
+	eq := func(a, b int) bool { return a == b }
+	for name, newMap := range maps {
+		t.Run(name, func(t *testing.T) {
+			m := newMap()
+			m.Set("before", 0)
+			ctx, cancel := context.WithCancel(context.Background())
+			all := m.Watch(ctx)
+			one := m.WatchKey(ctx, "a")
+
+			m.Set("a", 1)
+			m.Set("a", 2)
+			m.SetIfNotExists("b", 3)
+			m.Compute("a", func(old int, exists bool) (int, ComputeAction) { return old, ComputeKeep })
+			m.Compute("b", func(old int, exists bool) (int, ComputeAction) { return old + 1, ComputeSet })
+			m.CompareAndSwap("a", 2, 5, eq)
+			m.Delete("missing")
+			m.Delete("a")
+			m.GetAndDelete("b")
+
+			expected := []Event[string, int]{
+				{Type: EventPut, Key: "a", New: 1},
+				{Type: EventUpdate, Key: "a", Old: 1, New: 2},
+				{Type: EventPut, Key: "b", New: 3},
+				{Type: EventUpdate, Key: "b", Old: 3, New: 4},
+				{Type: EventUpdate, Key: "a", Old: 2, New: 5},
+				{Type: EventDelete, Key: "a", Old: 5},
+				{Type: EventDelete, Key: "b", Old: 4},
+			}
+			assert.Equal(t, expected, readEvents(all, len(expected)))
+			assert.Equal(t, []Event[string, int]{expected[0], expected[1], expected[4], expected[5]}, readEvents(one, 4))
+
+			cancel()
+			_, ok := <-all
+			assert.False(t, ok)
+			_, ok = <-one
+			assert.False(t, ok)
+			m.Set("a", 1)
+		})
+	}
+}
+
+func TestWatch_DropPolicy(t *testing.T) {
+	m := NewSimpleMap[int, int]()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	oldest := m.Watch(ctx, WithWatchBuffer(2))
+	newest := m.Watch(ctx, WithWatchBuffer(2), WithDropPolicy(DropNewest))
+	for i := 0; i < 5; i++ {
+		m.Set(i, i)
+	}
+	keys := func(events []Event[int, int]) []int {
+		var res []int
+		for _, e := range events {
+			res = append(res, e.Key)
+		}
+		return res
+	}
+	assert.Equal(t, []int{3, 4}, keys(readEvents(oldest, 2)))
+	assert.Equal(t, []int{0, 1}, keys(readEvents(newest, 2)))
+}
+
+func TestWatch_ShardMapExpiry(t *testing.T) {
+	clock := &testClock{now: time.Now()}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	m := NewShardMap[string, int]().WithContext(ctx).WithClock(clock).WithJanitorInterval(5 * time.Millisecond).Build()
+	events := m.WatchKey(ctx, "session")
+	m.SetWithTTL("session", 1, time.Second)
+	clock.Add(2 * time.Second)
+	assert.Equal(t, []Event[string, int]{
+		{Type: EventPut, Key: "session", New: 1},
+		{Type: EventDelete, Key: "session", Old: 1},
+	}, readEvents(events, 2))
+}
+
+func TestWatch_TwoKeyMap(t *testing.T) {
+	m := NewTwoKeyMap[string, int, string]()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events := m.Watch(ctx)
+	m.Put("a", 1, "x")
+	m.Put("a", 1, "y")
+	m.RemoveByK2(1)
+	m.Remove("a")
+	assert.Equal(t, []Event[string, string]{
+		{Type: EventPut, Key: "a", New: "x"},
+		{Type: EventUpdate, Key: "a", Old: "x", New: "y"},
+		{Type: EventDelete, Key: "a", Old: "y"},
+	}, readEvents(events, 3))
+	select {
+	case e := <-events:
+		t.Fatalf("unexpected event %v", e)
+	default:
+	}
+}
+
+func readEvents[K comparable, V any](ch <-chan Event[K, V], n int) []Event[K, V] {
+	var res []Event[K, V]
+	timeout := time.After(time.Second)
+	for len(res) < n {
+		select {
+		case e := <-ch:
+			res = append(res, e)
+		case <-timeout:
+			return res
+		}
+	}
+	return res
+}